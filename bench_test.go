@@ -0,0 +1,43 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"go/ast"
+	"testing"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// BenchmarkVisit measures the analysis of a large package, without
+// loading it, which BenchmarkUnconvert includes.
+func BenchmarkVisit(b *testing.B) {
+	cfg := &packages.Config{Mode: packages.NeedSyntax | packages.NeedTypes | packages.NeedTypesInfo}
+	pkgs, err := packages.Load(cfg, "cmd/compile/internal/ssa")
+	if err != nil {
+		b.Fatal(err)
+	}
+	if packages.PrintErrors(pkgs) != 0 {
+		b.Fatal("errors loading packages")
+	}
+	pkg := pkgs[0]
+	opts := &options{Generated: "include", Vendor: "report"}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, file := range pkg.Syntax {
+			v := visitor{
+				opts:  opts,
+				fset:  pkg.Fset,
+				pkg:   pkg.Types,
+				info:  pkg.TypesInfo,
+				file:  pkg.Fset.File(file.Package),
+				src:   sourceFile{},
+				edits: make(editSet),
+			}
+			ast.Walk(&v, file)
+		}
+	}
+}
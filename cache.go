@@ -0,0 +1,305 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// cacheVersion is mixed into every cache key. Bump it whenever
+// the format of cache entries changes.
+const cacheVersion = "unconvert cache v6"
+
+// A resultsCache stores the edits found in each package on disk.
+// Entries are keyed by a hash of the package's source files, the
+// keys of its dependencies, and the settings in effect, so that
+// unchanged packages don't need to be loaded or type checked again.
+type resultsCache struct {
+	dir string
+
+	// hits holds the cached edits for packages that haven't changed.
+	hits fileToEditSet
+
+	// keys maps the IDs of packages that need to be analyzed to
+	// the keys their results should be saved under.
+	keys map[string]string
+
+	// results holds the edits found for each analyzed package,
+	// by package ID.
+	results map[string]fileToEditSet
+
+	// paths holds the package paths of the analyzed packages.
+	paths map[string]string
+}
+
+// A cacheEntry is the on-disk form of a package's results.
+type cacheEntry struct {
+	Files []cacheFile
+}
+
+type cacheFile struct {
+	Name  string
+	Edits []cacheEdit
+}
+
+type cacheEdit struct {
+	Pos  token.Position
+	Edit edit
+}
+
+// openCache loads the metadata (but not the syntax or types) of the
+// packages matched by patterns, computes their cache keys, and
+// looks up their cached results in opts.CacheDir.
+func openCache(opts *options, cfg *packages.Config, patterns []string) (*resultsCache, error) {
+	dir := opts.CacheDir
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+
+	meta := *cfg
+	meta.Mode = packages.NeedName | packages.NeedFiles | packages.NeedImports | packages.NeedDeps | packages.NeedModule
+	roots, err := packages.Load(&meta, patterns...)
+	if err != nil {
+		return nil, err
+	}
+	pkgs := analyzedPackages(opts, roots)
+
+	salt, err := cacheSalt(opts, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &resultsCache{
+		dir:     dir,
+		hits:    make(fileToEditSet),
+		keys:    make(map[string]string),
+		results: make(map[string]fileToEditSet),
+		paths:   make(map[string]string),
+	}
+
+	memo := make(map[*packages.Package]string)
+	for _, pkg := range pkgs {
+		key, err := packageKey(pkg, salt, memo)
+		if err != nil || len(pkg.Errors) != 0 {
+			// Analyze it normally; the errors will be
+			// reported when it's loaded in full.
+			c.keys[pkg.ID] = ""
+			c.paths[pkg.ID] = pkg.PkgPath
+			continue
+		}
+		if c.lookup(key) {
+			continue
+		}
+		c.keys[pkg.ID] = key
+		c.paths[pkg.ID] = pkg.PkgPath
+	}
+	return c, nil
+}
+
+// lookup adds the cached results for key to c.hits, and reports
+// whether there were any.
+func (c *resultsCache) lookup(key string) bool {
+	buf, err := os.ReadFile(filepath.Join(c.dir, key))
+	if err != nil {
+		return false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(buf, &entry); err != nil {
+		return false
+	}
+	for _, f := range entry.Files {
+		edits := make(editSet)
+		for _, e := range f.Edits {
+			edits.add(e.Pos, e.Edit)
+		}
+		c.hits[f.Name] = edits
+	}
+	return true
+}
+
+// missed reports whether pkg needs to be analyzed.
+func (c *resultsCache) missed(pkg *packages.Package) bool {
+	_, ok := c.keys[pkg.ID]
+	return ok
+}
+
+// missPatterns returns the patterns to load the packages that
+// need to be analyzed. If they can't be named by import path,
+// the original patterns are returned.
+func (c *resultsCache) missPatterns(patterns []string) []string {
+	seen := make(map[string]bool)
+	var res []string
+	for _, path := range c.paths {
+		// Test variants are loaded along with the package
+		// under test.
+		path = strings.TrimSuffix(path, ".test")
+		path = strings.TrimSuffix(path, "_test")
+		if path == "command-line-arguments" {
+			return patterns
+		}
+		if !seen[path] {
+			seen[path] = true
+			res = append(res, path)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// record notes the edits found in file, which belongs to pkg.
+func (c *resultsCache) record(pkg *packages.Package, file string, edits editSet) {
+	m := c.results[pkg.ID]
+	if m == nil {
+		m = make(fileToEditSet)
+		c.results[pkg.ID] = m
+	}
+	m[file] = edits
+}
+
+// save writes the results of the analyzed packages among pkgs to
+// the cache. Packages with errors aren't saved, since their results
+// may be incomplete.
+func (c *resultsCache) save(pkgs []*packages.Package) {
+	for _, pkg := range pkgs {
+		key := c.keys[pkg.ID]
+		if key == "" || len(pkg.Errors) != 0 {
+			continue
+		}
+
+		var entry cacheEntry
+		for name, edits := range c.results[pkg.ID] {
+			f := cacheFile{Name: name}
+			for pos, ed := range edits {
+				f.Edits = append(f.Edits, cacheEdit{pos, ed})
+			}
+			entry.Files = append(entry.Files, f)
+		}
+		buf, err := json.Marshal(entry)
+		if err != nil {
+			continue
+		}
+
+		// Write to a temporary file and rename, so concurrent
+		// runs never see a partial entry.
+		tmp, err := os.CreateTemp(c.dir, key+".*")
+		if err != nil {
+			continue
+		}
+		_, err = tmp.Write(buf)
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), filepath.Join(c.dir, key))
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}
+}
+
+// packageKey returns the cache key for pkg, which covers the contents
+// of its files and, recursively, those of its dependencies.
+func packageKey(pkg *packages.Package, salt []byte, memo map[*packages.Package]string) (string, error) {
+	if key, ok := memo[pkg]; ok {
+		return key, nil
+	}
+
+	h := sha256.New()
+	h.Write(salt)
+	fmt.Fprintf(h, "package %s\n", pkg.ID)
+
+	files := append(append([]string(nil), pkg.GoFiles...), pkg.OtherFiles...)
+	sort.Strings(files)
+	for _, file := range files {
+		sum, err := fileHash(file)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "file %s %s\n", file, sum)
+	}
+
+	paths := make([]string, 0, len(pkg.Imports))
+	for path := range pkg.Imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		key, err := packageKey(pkg.Imports[path], salt, memo)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "import %s %s\n", path, key)
+	}
+
+	key := hex.EncodeToString(h.Sum(nil))
+	memo[pkg] = key
+	return key, nil
+}
+
+// cacheSalt returns a hash of everything besides package contents
+// that can affect the results: the unconvert binary itself, the
+// build configuration, and the options.
+func cacheSalt(opts *options, cfg *packages.Config) ([]byte, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n", cacheVersion)
+
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	sum, err := fileHash(exe)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(h, "exe %s\n", sum)
+
+	for _, kv := range cfg.Env {
+		if strings.HasPrefix(kv, "GO") || strings.HasPrefix(kv, "CGO_") ||
+			strings.HasPrefix(kv, "CC=") || strings.HasPrefix(kv, "CXX=") {
+			fmt.Fprintf(h, "env %s\n", kv)
+		}
+	}
+	fmt.Fprintf(h, "buildflags %q\n", cfg.BuildFlags)
+	fmt.Fprintf(h, "tests %v\n", cfg.Tests)
+
+	buf, err := json.Marshal(opts)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(h, "options %s\n", buf)
+	if wd, err := os.Getwd(); err == nil {
+		// Relative file names depend on the working directory.
+		fmt.Fprintf(h, "wd %s\n", wd)
+	}
+
+	return h.Sum(nil), nil
+}
+
+// fileHash returns the hex-encoded SHA-256 hash of file's contents.
+func fileHash(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
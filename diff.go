@@ -0,0 +1,189 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// diffContext is the number of unchanged lines around each hunk.
+const diffContext = 3
+
+// A diffOp is a line of a diff: kept (' '), deleted ('-'), or
+// inserted ('+').
+type diffOp struct {
+	kind byte
+	line []byte
+}
+
+// unifiedDiff returns a unified diff from old to new, with file
+// names a/name and b/name like git's, or nil if they're the same.
+func unifiedDiff(name string, old, new []byte) []byte {
+	if bytes.Equal(old, new) {
+		return nil
+	}
+	ops := diffLines(splitLines(old), splitLines(new))
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- a/%s\n+++ b/%s\n", name, name)
+
+	// aLines[i] and bLines[i] count the old and new lines
+	// before ops[i].
+	aLines := make([]int, len(ops)+1)
+	bLines := make([]int, len(ops)+1)
+	for i, op := range ops {
+		aLines[i+1], bLines[i+1] = aLines[i], bLines[i]
+		if op.kind != '+' {
+			aLines[i+1]++
+		}
+		if op.kind != '-' {
+			bLines[i+1]++
+		}
+	}
+
+	i := 0
+	for {
+		for i < len(ops) && ops[i].kind == ' ' {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+
+		// Extend the hunk over changes that are separated by
+		// few enough unchanged lines to share their context.
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for {
+			for end < len(ops) && ops[end].kind != ' ' {
+				end++
+			}
+			next := end
+			for next < len(ops) && ops[next].kind == ' ' {
+				next++
+			}
+			if next < len(ops) && next-end <= 2*diffContext {
+				end = next
+				continue
+			}
+			end += diffContext
+			if end > next {
+				end = next
+			}
+			break
+		}
+
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n",
+			hunkRange(aLines[start], aLines[end]-aLines[start]),
+			hunkRange(bLines[start], bLines[end]-bLines[start]))
+		for _, op := range ops[start:end] {
+			buf.WriteByte(op.kind)
+			buf.Write(op.line)
+			if !bytes.HasSuffix(op.line, nl) {
+				buf.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+	return buf.Bytes()
+}
+
+// hunkRange formats the range of count lines after the first start
+// lines for a hunk header.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		// An empty range names the line before it.
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprint(start + 1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// splitLines splits b into lines, keeping their newlines.
+func splitLines(b []byte) [][]byte {
+	var lines [][]byte
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n') + 1
+		if i == 0 {
+			i = len(b)
+		}
+		lines = append(lines, b[:i])
+		b = b[i:]
+	}
+	return lines
+}
+
+// diffLines returns the shortest edit script from a to b, using
+// Myers' algorithm. Unnecessary conversions are few and far between,
+// so the number of differences, which bounds its cost, is small.
+func diffLines(a, b [][]byte) []diffOp {
+	n, m := len(a), len(b)
+	off := n + m + 1
+	v := make([]int, 2*off+1) // furthest x reached on diagonal k, at v[off+k]
+
+	// trace[d] holds the part of v that step d read, v[off-d-1]
+	// through v[off+d+1].
+	var trace [][]int
+	x, y := 0, 0
+search:
+	for d := 0; d <= n+m; d++ {
+		trace = append(trace, append([]int(nil), v[off-d-1:off+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y = x - k
+			for x < n && y < m && bytes.Equal(a[x], b[y]) {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	// Walk back from the end to recover the edits.
+	var ops []diffOp
+	for d := len(trace) - 1; d >= 0; d-- {
+		v, off := trace[d], d+1
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[off+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			ops = append(ops, diffOp{' ', a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, diffOp{'+', b[y-1]})
+			} else {
+				ops = append(ops, diffOp{'-', a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
@@ -0,0 +1,127 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// numbered returns n numbered lines, with the lines in repl replaced.
+func numbered(n int, repl map[int]string) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		if s, ok := repl[i]; ok {
+			b.WriteString(s + "\n")
+		} else {
+			fmt.Fprintf(&b, "%d\n", i)
+		}
+	}
+	return b.String()
+}
+
+var diffTests = []struct {
+	name     string
+	old, new string
+	want     string
+}{
+	{
+		name: "same",
+		old:  "a\nb\n",
+		new:  "a\nb\n",
+		want: "",
+	},
+	{
+		name: "insert",
+		old:  "a\nb\n",
+		new:  "a\nx\nb\n",
+		want: "@@ -1,2 +1,3 @@\n a\n+x\n b\n",
+	},
+	{
+		name: "delete",
+		old:  "a\nx\nb\n",
+		new:  "a\nb\n",
+		want: "@@ -1,3 +1,2 @@\n a\n-x\n b\n",
+	},
+	{
+		name: "empty old",
+		old:  "",
+		new:  "a\n",
+		want: "@@ -0,0 +1 @@\n+a\n",
+	},
+	{
+		name: "empty new",
+		old:  "a\nb\n",
+		new:  "",
+		want: "@@ -1,2 +0,0 @@\n-a\n-b\n",
+	},
+	{
+		name: "no final newline",
+		old:  "a\nb",
+		new:  "a\nc",
+		want: "@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n",
+	},
+	{
+		name: "add final newline",
+		old:  "a",
+		new:  "a\n",
+		want: "@@ -1 +1 @@\n-a\n\\ No newline at end of file\n+a\n",
+	},
+	{
+		// Changes 2*diffContext lines apart share a hunk.
+		name: "merge",
+		old:  numbered(14, nil),
+		new:  numbered(14, map[int]string{1: "x", 8: "y"}),
+		want: "@@ -1,11 +1,11 @@\n-1\n+x\n 2\n 3\n 4\n 5\n 6\n 7\n-8\n+y\n 9\n 10\n 11\n",
+	},
+	{
+		// One more line apart, and they don't.
+		name: "split",
+		old:  numbered(16, nil),
+		new:  numbered(16, map[int]string{1: "x", 9: "y"}),
+		want: "@@ -1,4 +1,4 @@\n-1\n+x\n 2\n 3\n 4\n@@ -6,7 +6,7 @@\n 6\n 7\n 8\n-9\n+y\n 10\n 11\n 12\n",
+	},
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	for _, test := range diffTests {
+		got := string(unifiedDiff("f.go", []byte(test.old), []byte(test.new)))
+		want := test.want
+		if want != "" {
+			want = "--- a/f.go\n+++ b/f.go\n" + want
+		}
+		if got != want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, got, want)
+		}
+	}
+}
+
+func TestUnifiedDiffGitApply(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	for _, test := range diffTests {
+		if test.want == "" {
+			continue
+		}
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "f.go"), []byte(test.old), 0666); err != nil {
+			t.Fatal(err)
+		}
+		patch := filepath.Join(dir, "f.patch")
+		if err := os.WriteFile(patch, unifiedDiff("f.go", []byte(test.old), []byte(test.new)), 0666); err != nil {
+			t.Fatal(err)
+		}
+		cmd := exec.Command("git", "apply", "--check", patch)
+		cmd.Dir = dir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Errorf("%s: git apply --check: %v\n%s", test.name, err, output)
+		}
+	}
+}
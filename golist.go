@@ -0,0 +1,187 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// goListEnv is the environment variable that holds the -golist file
+// when unconvert runs as its own go/packages driver.
+//
+// go/packages runs the program named by GOPACKAGESDRIVER, if any,
+// instead of go list to find the packages to load. With -golist,
+// unconvert names itself, and answers from the file, so the packages
+// are still parsed and type checked as usual, but not resolved again.
+const goListEnv = "UNCONVERT_GOLIST"
+
+// A goListPackage holds the fields of a package in the output of
+// go list -json that are needed to load it.
+type goListPackage struct {
+	Dir             string
+	ImportPath      string
+	Name            string
+	Export          string
+	GoFiles         []string
+	CgoFiles        []string
+	CompiledGoFiles []string
+	Imports         []string
+	ImportMap       map[string]string
+	DepOnly         bool
+	Error           *struct {
+		Pos string
+		Err string
+	}
+}
+
+// A goListResponse is the response of a go/packages driver.
+type goListResponse struct {
+	Compiler string
+	Arch     string
+	Roots    []string
+	Packages []*packages.Package
+}
+
+// readGoList returns the packages in the output of go list -json.
+func readGoList(r io.Reader) ([]*goListPackage, error) {
+	var list []*goListPackage
+	dec := json.NewDecoder(r)
+	for {
+		p := new(goListPackage)
+		if err := dec.Decode(p); err == io.EOF {
+			return list, nil
+		} else if err != nil {
+			return nil, err
+		}
+		list = append(list, p)
+	}
+}
+
+// goListDriverFile returns the -golist file if this process was
+// run as the go/packages driver for it, that is, if GOPACKAGESDRIVER
+// names this executable as well. A goListEnv left in the environment
+// of an ordinary run isn't enough.
+func goListDriverFile() (string, bool) {
+	file := os.Getenv(goListEnv)
+	driver := os.Getenv("GOPACKAGESDRIVER")
+	if file == "" || driver == "" {
+		return "", false
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return "", false
+	}
+	fi1, err := os.Stat(driver)
+	if err != nil {
+		return "", false
+	}
+	fi2, err := os.Stat(exe)
+	if err != nil {
+		return "", false
+	}
+	return file, os.SameFile(fi1, fi2)
+}
+
+// goListDriver answers the go/packages driver request on standard
+// input with the packages in file, the output of go list -json.
+// The packages in it that aren't only dependencies are the roots.
+func goListDriver(file string) error {
+	var req struct {
+		Env []string `json:"env"`
+	}
+	if err := json.NewDecoder(os.Stdin).Decode(&req); err != nil {
+		return err
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	list, err := readGoList(f)
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		return errors.New(file + ": no packages")
+	}
+
+	resp := goListResponse{Compiler: "gc", Arch: runtime.GOARCH}
+	for _, kv := range req.Env {
+		if arch, ok := strings.CutPrefix(kv, "GOARCH="); ok && arch != "" {
+			resp.Arch = arch
+		}
+	}
+	for _, p := range list {
+		// Test variants have IDs like "p [p.test]".
+		path, _, _ := strings.Cut(p.ImportPath, " ")
+		pkg := &packages.Package{
+			ID:         p.ImportPath,
+			Name:       p.Name,
+			PkgPath:    path,
+			ExportFile: p.Export,
+			Imports:    make(map[string]*packages.Package),
+		}
+		pkg.GoFiles = absFiles(p.Dir, append(p.GoFiles, p.CgoFiles...))
+		pkg.CompiledGoFiles = pkg.GoFiles
+		if p.CompiledGoFiles != nil {
+			// With go list -compiled, these include the
+			// files generated by cgo, which are needed to
+			// type check packages that use it.
+			pkg.CompiledGoFiles = absFiles(p.Dir, p.CompiledGoFiles)
+		}
+		if p.Error != nil {
+			pkg.Errors = append(pkg.Errors, packages.Error{
+				Pos:  p.Error.Pos,
+				Msg:  p.Error.Err,
+				Kind: packages.ListError,
+			})
+		}
+
+		// Imports lists the packages as resolved, and
+		// ImportMap maps the paths in the source to them
+		// where they differ.
+		srcPaths := make(map[string]string)
+		for src, id := range p.ImportMap {
+			srcPaths[id] = src
+		}
+		for _, id := range p.Imports {
+			if id == "C" {
+				continue
+			}
+			src, ok := srcPaths[id]
+			if !ok {
+				src = id
+			}
+			pkg.Imports[src] = &packages.Package{ID: id}
+		}
+
+		resp.Packages = append(resp.Packages, pkg)
+		if !p.DepOnly {
+			resp.Roots = append(resp.Roots, pkg.ID)
+		}
+	}
+	return json.NewEncoder(os.Stdout).Encode(resp)
+}
+
+// absFiles returns the names of files in dir as absolute paths.
+func absFiles(dir string, files []string) []string {
+	res := make([]string, len(files))
+	for i, file := range files {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		res[i] = file
+	}
+	return res
+}
@@ -0,0 +1,58 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"os"
+)
+
+// mergeReports reads the -json reports in files, as written by
+// separate runs over parts of a repository, and returns their
+// findings as one list. Findings with the same fingerprint, like
+// those in packages that more than one run analyzed, are only
+// returned once.
+func mergeReports(files []string) ([]conversion, error) {
+	var res []conversion
+	seen := make(map[string]bool)
+	for _, file := range files {
+		buf, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var report jsonOutput
+		if err := json.Unmarshal(buf, &report); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		if report.Version != jsonVersion {
+			return nil, fmt.Errorf("%s: unsupported report version %d, want %d", file, report.Version, jsonVersion)
+		}
+
+		for _, f := range report.Findings {
+			key := f.Fingerprint
+			if key == "" {
+				key = fmt.Sprintf("%s:%d:%d", f.File, f.Line, f.Col)
+			}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			res = append(res, conversion{
+				Position: token.Position{Filename: f.File, Line: f.Line, Column: f.Col},
+				edit: edit{
+					Text:      f.Text,
+					Type:      f.Type,
+					Func:      f.Func,
+					Rule:      f.Rule,
+					Generated: f.Generated,
+				},
+			})
+		}
+	}
+	return res, nil
+}
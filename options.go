@@ -0,0 +1,177 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"sort"
+	"text/template"
+	"time"
+)
+
+// options holds the settings for a run of unconvert. main fills them
+// in from the command-line flags and passes them explicitly to the
+// analysis and printing functions, rather than those reading the
+// flags themselves.
+//
+// The fields that affect which edits are found are also part of the
+// results cache's keys; the others are tagged to be left out. Configs
+// is left out too, since it's part of the environment, which is
+// keyed separately.
+type options struct {
+	// Loading.
+	Configs    [][]string `json:"-"` // extra environment for each configuration to analyze
+	Tags       string     // space-separated build tags
+	ModFile    string     // alternate go.mod file
+	Tests      bool       // include test files
+	NoCgo      bool       // disable cgo and skip files that import "C"
+	Deps       bool       // also analyze dependencies
+	DepsPrefix string     // with Deps, package path prefix of dependencies to analyze
+	GoList     string     // file of go list -json output to load packages from, instead of running go list
+	QuietLoad  bool       `json:"-"` // ignore errors in packages
+
+	// Which files to analyze.
+	Include   string // comma-separated file glob patterns
+	Files     string // comma-separated file names
+	OnlyFile  string // single file to analyze, for -apply-stdout
+	Generated string // "include", "skip", or "report"
+	Vendor    string // "skip" or "report"
+	Reachable bool   // only packages that main packages import
+
+	// Which conversions to report, and which of those to only report.
+	Safe          bool
+	FastMath      bool
+	KeepInlining  bool
+	StrictUntyped bool
+	ExportedOnly  bool
+	Strict        bool
+	OnlySafe      bool
+	Disable       map[string]bool // rule IDs not to report
+	IgnorePkgs    map[string]bool // paths of packages whose types' conversions aren't reported
+
+	NoFormat  bool              `json:"-"` // don't gofmt files after applying edits
+	FirstOnly bool              `json:"-"` // report at most one conversion per file, applied after computing them
+	CacheDir  string            `json:"-"` // results cache directory
+	Overlay   map[string][]byte `json:"-"` // file contents to use instead of the files on disk
+	Timeout   time.Duration     `json:"-"` // give up after this long, if nonzero
+
+	// What to do with the edits besides reporting them. At most
+	// one of these is set.
+	Apply       bool   `json:"-"` // apply them to the files
+	ApplyStdout bool   `json:"-"` // apply them to OnlyFile and print the result
+	PatchDir    string `json:"-"` // directory to write them to as patches
+	Script      string `json:"-"` // file to write them to as a shell script
+	Merge       bool   `json:"-"` // print the findings in -json reports instead of analyzing
+
+	// Output.
+	Paths     string             `json:"-"` // "relative", "absolute", or "" for as given
+	PathStrip string             `json:"-"` // prefix to strip from file paths
+	F         string             `json:"-"` // staticcheck format
+	Format    *template.Template `json:"-"` // -format template
+	XML       bool               `json:"-"` // Checkstyle report
+	JSON      bool               `json:"-"` // JSON report
+	Severity  string             `json:"-"` // severity in structured reports
+	ShowTypes bool               `json:"-"`
+	Explain   bool               `json:"-"`
+	Verbose   bool               `json:"-"`
+	Out       string             `json:"-"` // file to write the report to, printing the default format instead
+	Stats     bool               `json:"-"` // only print a summary
+	Count     bool               `json:"-"` // only print the number of findings
+	Quiet     bool               `json:"-"` // print nothing
+}
+
+// optionsFromFlags returns the options set by the command-line
+// flags, which must already be parsed and validated. It leaves
+// Configs, Format, GoList, and Overlay to the caller.
+func optionsFromFlags() *options {
+	return &options{
+		Tags:       *flagTags,
+		ModFile:    *flagModFile,
+		Tests:      *flagTests,
+		NoCgo:      *flagNoCgo,
+		Deps:       *flagDeps,
+		DepsPrefix: *flagPrefix,
+		QuietLoad:  *flagQuietLd,
+
+		Include:   *flagInclude,
+		Files:     *flagFiles,
+		OnlyFile:  *flagStdout,
+		Generated: *flagGen,
+		Vendor:    *flagVendor,
+		Reachable: *flagReach,
+
+		Safe:          *flagSafe,
+		FastMath:      *flagFastMath,
+		KeepInlining:  *flagInlining,
+		StrictUntyped: *flagUntyped,
+		ExportedOnly:  *flagExported,
+		Strict:        *flagStrict,
+		OnlySafe:      *flagOnlySafe,
+		Disable:       listSet(*flagDisable),
+		FirstOnly:     *flagFirst,
+		IgnorePkgs:    listSet(*flagIgnore),
+
+		NoFormat: *flagNoFormat,
+		CacheDir: *flagCacheDir,
+		Timeout:  *flagTimeout,
+
+		Apply:       *flagApply,
+		ApplyStdout: *flagStdout != "",
+		PatchDir:    *flagPatchDir,
+		Script:      *flagScript,
+		Merge:       *flagMerge,
+
+		Paths:     *flagPaths,
+		PathStrip: *flagStrip,
+		F:         *flagF,
+		XML:       *flagXML,
+		JSON:      *flagJSON,
+		Severity:  *flagSeverity,
+		ShowTypes: *flagTypes,
+		Explain:   *flagExplain,
+		Verbose:   *flagV,
+		Out:       *flagOut,
+		Stats:     *flagStats,
+		Count:     *flagCount,
+		Quiet:     *flagQuiet,
+	}
+}
+
+// printOptions prints opts and the package patterns to analyze as a
+// JSON object, for -config-print. Unlike the cache keys, it includes
+// every field. The -format template and Timeout are printed as text,
+// and overlaid files by name only.
+func printOptions(w io.Writer, opts *options, patterns []string) error {
+	out := map[string]any{"Patterns": patterns}
+	v := reflect.ValueOf(opts).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		val := v.Field(i).Interface()
+		switch x := val.(type) {
+		case *template.Template:
+			if x != nil {
+				val = x.Root.String()
+			}
+		case time.Duration:
+			val = x.String()
+		case map[string][]byte:
+			names := make([]string, 0, len(x))
+			for name := range x {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			val = names
+		}
+		out[v.Type().Field(i).Name] = val
+	}
+
+	buf, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(buf, '\n'))
+	return err
+}
@@ -0,0 +1,11 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testdata
+
+// Only built on linux; see build_other.go for other platforms.
+func _() {
+	var x int
+	_ = int(x) //@ unnecessary conversion
+}
@@ -0,0 +1,15 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux || unconvert_other
+
+package testdata
+
+// Built everywhere but linux, and on linux too with the
+// unconvert_other tag; see build_linux.go.
+func _() {
+	var x, y int
+	_ = int(x) //@ unnecessary conversion
+	_ = int(y) //@ unnecessary conversion
+}
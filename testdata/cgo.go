@@ -8,6 +8,8 @@ package testdata
 // void bar(int* x) {}
 import "C"
 
+import "unsafe"
+
 // Basic validity tests for C calls.
 func _() {
 	C.foo(0)
@@ -23,3 +25,22 @@ func _() {
 func _() interface{} {
 	return C.foo
 }
+
+// Pointer conversions mixing unsafe.Pointer and C types
+// are needed; only conversions to the identical type aren't.
+func _(p unsafe.Pointer, q *C.int, a *[4]C.int) {
+	C.bar((*C.int)(p))
+	C.bar((*C.int)(unsafe.Pointer(q)))
+	C.bar((*C.int)(unsafe.Pointer(&a[0])))
+	C.bar((*C.int)(q)) //@ unnecessary conversion
+	C.bar(&a[0])
+
+	_ = unsafe.Pointer(q)
+	_ = unsafe.Pointer(p) //@ unnecessary conversion
+	_ = (*C.char)(unsafe.Pointer(q))
+	_ = (*C.char)(p)
+	_ = (*[4]C.int)(unsafe.Pointer(q))
+	_ = (*[4]C.int)(a) //@ unnecessary conversion
+	_ = (*[2]C.int)(unsafe.Pointer(a))
+	_ = uintptr(unsafe.Pointer(q))
+}
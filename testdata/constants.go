@@ -0,0 +1,82 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testdata
+
+// Conversions of typed constant expressions. These are
+// unnecessary, but aren't reported with -strict-untyped.
+func _() {
+	type Counter int64
+
+	const K int = 1
+	const S string = "s"
+	const (
+		C Counter = 2
+		D         = C * 2
+	)
+
+	_ = int(K)      //@ unnecessary conversion
+	_ = int(K + 1)  //@ unnecessary conversion
+	_ = string(S)   //@ unnecessary conversion
+	_ = Counter(C)  //@ unnecessary conversion
+	_ = Counter(D)  //@ unnecessary conversion
+	_ = int(len(S)) //@ unnecessary conversion
+}
+
+// Conversions of typed constants in array lengths.
+func _() {
+	const N int = 4
+
+	var _ [int(N)]int     //@ unnecessary conversion
+	var _ [int(N) * 2]int //@ unnecessary conversion
+	var _ [int64(N)]int
+}
+
+// Typed and untyped named constants. Only conversions of typed
+// constants to their own type are unnecessary; converting an untyped
+// constant gives it its type.
+const (
+	TypedK   int = 10
+	UntypedK     = 10
+	ConvK        = int(10) // typed by the conversion
+	UntypedF     = 1.5
+)
+
+var (
+	_ = int(TypedK) //@ unnecessary conversion
+	_ = int(UntypedK)
+	_ = int(ConvK) //@ unnecessary conversion
+	_ = float64(UntypedF)
+)
+
+func _() {
+	const local = 3
+	const localInt int = 3
+
+	_ = int(local)
+	_ = int(local * 2)
+	_ = int(localInt)            //@ unnecessary conversion
+	_ = int(localInt + UntypedK) //@ unnecessary conversion
+	_ = int(UntypedK + TypedK)   //@ unnecessary conversion
+	_ = int(UntypedK + local)
+	_ = int64(TypedK)
+	_ = uint8(UntypedK)
+
+	var x int = int(UntypedK)
+	var y int = int(TypedK) //@ unnecessary conversion
+	_, _ = x, y
+}
+
+// Const declarations with explicit types.
+func _() {
+	const a int = int(TypedK) //@ unnecessary conversion
+	const b int = int(UntypedK)
+	const c int64 = int64(TypedK)
+	const (
+		d string  = string("s")
+		e float64 = float64(UntypedF)
+		f int     = int(a + 1) //@ unnecessary conversion
+	)
+	_, _, _, _, _, _ = a, b, c, d, e, f
+}
@@ -0,0 +1,42 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testdata
+
+import (
+	_ "embed"
+	"unsafe"
+)
+
+// Compiler directives on functions and variables don't change which
+// conversions are reported.
+
+//go:embed keep.go
+var keepSrc string
+
+//go:noinline
+func noinline(x int) int {
+	return int(x) //@ unnecessary conversion
+}
+
+// nosplit has a doc comment before its directive.
+//
+//go:nosplit
+func nosplit(p unsafe.Pointer, n uintptr) uintptr {
+	_ = unsafe.Pointer(p) //@ unnecessary conversion
+	return uintptr(n)     //@ unnecessary conversion
+}
+
+//go:noinline
+//go:nosplit
+func both(s string) int {
+	_ = string(keepSrc)   //@ unnecessary conversion
+	return len(string(s)) //@ unnecessary conversion
+}
+
+func _() {
+	_ = noinline(int(1))
+	_ = nosplit(nil, uintptr(2))
+	_ = both(keepSrc)
+}
@@ -0,0 +1,46 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testdata
+
+// Color is a stringer-style enum. Converting untyped constants gives
+// them the enum type, so none of these conversions are reported.
+type Color int
+
+const (
+	Red Color = Color(iota)
+	Green
+	Blue
+	numColors = Color(iota)
+)
+
+// Status is an enum whose values are computed from expressions.
+type Status uint8
+
+const (
+	StatusOK     = Status(0)
+	StatusFailed = Status(1 << iota)
+	StatusRetry
+	StatusMask = Status(StatusFailed | StatusRetry) //@ unnecessary conversion
+	StatusMax  = Status(^uint8(0))
+)
+
+var colorNames = [...]string{
+	Red:   "red",
+	Green: "green",
+	Blue:  "blue",
+}
+
+func (c Color) String() string {
+	if c < 0 || c >= numColors {
+		return "Color(" + string(rune('0'+int(c))) + ")"
+	}
+	return colorNames[c]
+}
+
+func _() {
+	_ = Color(2)
+	_ = Color(Blue)          //@ unnecessary conversion
+	_ = Status(StatusOK + 1) //@ unnecessary conversion
+}
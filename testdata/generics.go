@@ -0,0 +1,104 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testdata
+
+type ordered interface {
+	~int | ~int64 | ~float64
+}
+
+func Max[T ordered](a, b T) T {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func Sum[S ~[]E, E ordered](s S) E {
+	var sum E
+	for _, x := range s {
+		sum += E(x) //@ unnecessary conversion
+	}
+	return sum
+}
+
+type MyInt int
+
+type Pair[K comparable, V any] struct {
+	Key K
+	Val V
+}
+
+func NewPair[K comparable, V any](k K, v V) Pair[K, V] {
+	return Pair[K, V]{k, v}
+}
+
+// GenericArgs contains conversions of value arguments to generic
+// functions. Type arguments are inferred from the argument types, so
+// a conversion is only unnecessary if the argument already has the
+// converted type.
+func GenericArgs(a, b int, a32 int32, m, n MyInt, xs []int) {
+	_ = Max(int(a), b)                  //@ unnecessary conversion
+	_ = Max[int](int(a), b)             //@ unnecessary conversion
+	_ = Max(a, int(b))                  //@ unnecessary conversion
+	_ = Max(MyInt(m), n)                //@ unnecessary conversion
+	_ = Sum([]int(xs))                  //@ unnecessary conversion
+	_ = NewPair(string("k"+"v"[:1]), a) //@ unnecessary conversion
+	_ = Max(Max(int(a), b), int(b))     //@ unnecessary conversion //@ unnecessary conversion
+
+	// These conversions change the argument type, and with it
+	// the inferred type argument.
+	_ = Max(int(a32), b)
+	_ = Max(int(m), a)
+	_ = Max(MyInt(a), n)
+	_ = Sum([]MyInt(nil))
+
+	// Untyped constants would infer a different type.
+	_ = Max(int64(1), 2)
+	_ = Max(MyInt(1), 2)
+}
+
+// TypeParams contains conversions between type parameters.
+func TypeParams[T ~int, U ~int](t T, u U) {
+	_ = T(t) //@ unnecessary conversion
+	_ = U(u) //@ unnecessary conversion
+	_ = T(u)
+	_ = int(t)
+	_ = U(t)
+}
+
+type List[T any] struct {
+	Next *List[T]
+	Val  T
+}
+
+// Box doesn't use its type parameter, so all its instantiations have
+// the same underlying type.
+type Box[T any] struct {
+	N int
+}
+
+type IntList = List[int]
+
+// GenericPointers contains conversions to pointers to instantiated
+// generic types. Only a conversion to the same instantiation is
+// unnecessary.
+func GenericPointers[T any](p *List[int], b *Box[int], b32 *Box[int32], a *IntList, pt *List[T]) {
+	_ = (*List[int])(p)            //@ unnecessary conversion
+	_ = (*List[int])(p.Next)       //@ unnecessary conversion
+	_ = (*List[int])(a)            //@ unnecessary conversion
+	_ = (*IntList)(p)              //@ unnecessary conversion
+	_ = (*Box[int])(b)             //@ unnecessary conversion
+	_ = (*List[T])(pt)             //@ unnecessary conversion
+	_ = (*List[T])(pt.Next)        //@ unnecessary conversion
+	_ = (*List[int])(&List[int]{}) //@ unnecessary conversion
+
+	// Different instantiations are different types, even
+	// with the same underlying type.
+	_ = (*Box[int])(b32)
+	_ = (*Box[int32])(b)
+	_ = (*Box[T])(b)
+	_ = (*struct{ N int })(b)
+	_ = (*Box[int])(&struct{ N int }{})
+}
@@ -0,0 +1,21 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testdata
+
+// Conversions of function values that are immediately called.
+// These are only kept with -keep-inlining-hints.
+func _() {
+	type F func() int
+	var f func()
+	var g F
+
+	(func())(f)()   //@ unnecessary conversion
+	((func())(f))() //@ unnecessary conversion
+	_ = F(g)()      //@ unnecessary conversion
+	_ = (F)(g)()    //@ unnecessary conversion
+
+	defer (func())(f)() //@ unnecessary conversion
+	go (func())(f)()    //@ unnecessary conversion
+}
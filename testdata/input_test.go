@@ -4,7 +4,10 @@
 
 package testdata
 
-import "io"
+import (
+	"fmt"
+	"io"
+)
 
 // Basic contains conversion errors for builtin data types
 func Basic() {
@@ -116,6 +119,18 @@ func Interfaces() {
 
 	_ = (io.Writer)(writer)   //@ unnecessary conversion
 	_ = (*io.Writer)(&writer) //@ unnecessary conversion
+
+	// any is an alias for interface{}, so conversions
+	// between the two spellings are unnecessary too.
+	var vany any
+	var vempty interface{}
+
+	_ = any(vany)             //@ unnecessary conversion
+	_ = any(vempty)           //@ unnecessary conversion
+	_ = interface{}(vany)     //@ unnecessary conversion
+	_ = interface{}(vempty)   //@ unnecessary conversion
+	_ = (*any)(&vempty)       //@ unnecessary conversion
+	_ = (*interface{})(&vany) //@ unnecessary conversion
 }
 
 // Constructor is a func type
@@ -138,3 +153,580 @@ func Funcs() {
 	_ = (*Local)(&local)         //@ unnecessary conversion
 	_ = (*Recursive)(&recursive) //@ unnecessary conversion
 }
+
+// Builtins contains conversion errors in arguments to builtin functions
+func Builtins() {
+	var b byte
+	var n int
+	var n64 int64
+	var s []byte
+
+	_ = append(s, byte(b))      //@ unnecessary conversion
+	_ = append([]byte(s), b)    //@ unnecessary conversion
+	_ = append(s, []byte(s)...) //@ unnecessary conversion
+	_ = append(s, []byte("abc")...)
+
+	_ = make([]int, int(n))       //@ unnecessary conversion
+	_ = make([]int, 0, int(n))    //@ unnecessary conversion
+	_ = make(map[int]int, int(n)) //@ unnecessary conversion
+	_ = make(chan int, int(n))    //@ unnecessary conversion
+	_ = make([]int, int(n64))
+
+	_ = copy(s, []byte(s)) //@ unnecessary conversion
+	_ = copy([]byte(s), s) //@ unnecessary conversion
+	_ = copy(s, string(s))
+
+	_ = len([]byte(s)) //@ unnecessary conversion
+	_ = cap([]byte(s)) //@ unnecessary conversion
+	_ = int(len(s))    //@ unnecessary conversion
+}
+
+// Indexing contains conversion errors wrapping index and slice expressions
+func Indexing() {
+	var a []int
+	var arr [4]int
+	var m map[string]int
+	var s string
+	var b []byte
+	var i, j int
+
+	_ = int(a[i])       //@ unnecessary conversion
+	_ = int(arr[i])     //@ unnecessary conversion
+	_ = int(m[s])       //@ unnecessary conversion
+	_ = byte(s[i])      //@ unnecessary conversion
+	_ = byte(b[i])      //@ unnecessary conversion
+	_ = string(s[i:j])  //@ unnecessary conversion
+	_ = string(s[i:])   //@ unnecessary conversion
+	_ = []int(a[i:j])   //@ unnecessary conversion
+	_ = []int(arr[:])   //@ unnecessary conversion
+	_ = []int(a[i:j:j]) //@ unnecessary conversion
+	_ = []byte(b[:i:j]) //@ unnecessary conversion
+
+	_ = string(b[i:j])
+	_ = []byte(s[i:j])
+	_ = int64(a[i])
+	_ = rune(s[i])
+}
+
+// Deferred contains conversion errors in defer and go statements
+func Deferred() {
+	var n int
+	var id ID
+	f := func(int, ID) {}
+
+	defer f(int(n), id)          //@ unnecessary conversion
+	defer f(n, ID(id))           //@ unnecessary conversion
+	go f(int(n), id)             //@ unnecessary conversion
+	go f(n, ID(id))              //@ unnecessary conversion
+	defer func(x int) {}(int(n)) //@ unnecessary conversion
+	go func(x ID) {}(ID(id))     //@ unnecessary conversion
+
+	defer f(int(id[0]), id)
+}
+
+// SideEffects contains conversion errors wrapping expressions with side effects
+func SideEffects() {
+	var ch chan int
+	f := func() int { return 0 }
+
+	_ = int(f())     //@ unnecessary conversion
+	_ = int(<-ch)    //@ unnecessary conversion
+	_ = int(len(ch)) //@ unnecessary conversion
+}
+
+// Variadic contains conversion errors in arguments to variadic functions
+func Variadic() {
+	var a, b int
+	var xs []int
+	var ys []interface{}
+	f := func(xs ...int) {}
+
+	fmt.Println(int(a), int(b)) //@ unnecessary conversion //@ unnecessary conversion
+	fmt.Println(a, int(b))      //@ unnecessary conversion
+	fmt.Println(ys...)
+	fmt.Println([]interface{}(ys)...) //@ unnecessary conversion
+
+	f(int(a), b, int(b)) //@ unnecessary conversion //@ unnecessary conversion
+	f(xs...)
+	f([]int(xs)...) //@ unnecessary conversion
+}
+
+// Parens contains conversion errors with parenthesized types. See also
+// testdata/apply/parens.input, since gofmt removes nested parentheses.
+func Parens() {
+	var id ID
+	var m Metric
+
+	_ = (ID)(id)             //@ unnecessary conversion
+	_ = (*Metric)(&m)        //@ unnecessary conversion
+	_ = (*(Metric))(&m)      //@ unnecessary conversion
+	_ = (ID)((ID)(id))       //@ unnecessary conversion //@ unnecessary conversion
+	_ = (*Metric)((&m))      //@ unnecessary conversion
+	_ = ([]ID)([]ID{id, id}) //@ unnecessary conversion
+
+	_ = (func(ID))(nil)
+	_ = (string)(id)
+	_ = (*struct{})(nil)
+}
+
+// Literals contains conversions in the keys and values of composite
+// literals, where the expected type is implied by the literal's type.
+func Literals() {
+	var k int
+	var id ID
+	var c Counter
+	var i64 int64
+
+	_ = map[int]ID{int(k): id}      //@ unnecessary conversion
+	_ = map[int]ID{k: ID(id)}       //@ unnecessary conversion
+	_ = []ID{ID(id), id}            //@ unnecessary conversion
+	_ = [...]ID{2: ID(id)}          //@ unnecessary conversion
+	_ = Metric{ID: ID(id)}          //@ unnecessary conversion
+	_ = Metric{Counter: Counter(c)} //@ unnecessary conversion
+	_ = &Metric{ID(id), c}          //@ unnecessary conversion
+	_ = map[ID]map[int]Counter{
+		ID(id): {int(k): Counter(c)}, //@ unnecessary conversion //@ unnecessary conversion //@ unnecessary conversion
+	}
+
+	_ = map[int64]ID{int64(k): id}
+	_ = map[ID]int64{id: int64(c)}
+	_ = Metric{Counter: Counter(i64)}
+	_ = []string{string(id)}
+}
+
+// Closures contains conversions inside function literals.
+func Closures() {
+	var id ID
+	var c Counter
+
+	f := func() ID {
+		return ID(id) //@ unnecessary conversion
+	}
+	_ = f
+
+	func() {
+		_ = Counter(c) //@ unnecessary conversion
+	}()
+
+	_ = func(x ID) ID { return ID(x) }(id) //@ unnecessary conversion
+
+	g := func(n int64) func() Counter {
+		return func() Counter {
+			_ = Counter(c) //@ unnecessary conversion
+			return Counter(n)
+		}
+	}
+	_ = g
+
+	go func(x Counter) {
+		_ = int64(x)
+	}(Counter(c)) //@ unnecessary conversion
+}
+
+// Assertions contains conversions of type assertion results.
+func Assertions() {
+	var x interface{}
+	var w io.Writer
+
+	_ = int(x.(int))                            //@ unnecessary conversion
+	_ = ID(x.(ID))                              //@ unnecessary conversion
+	_ = io.Writer(x.(io.Writer))                //@ unnecessary conversion
+	_ = io.Writer(w.(fmt.Stringer).(io.Writer)) //@ unnecessary conversion
+	_ = (*Metric)(x.(*Metric))                  //@ unnecessary conversion
+
+	if v, ok := x.(int); ok {
+		_ = int(v) //@ unnecessary conversion
+	}
+
+	_ = int64(x.(int))
+	_ = string(x.(ID))
+	_ = io.Reader(x.(io.ReadWriter))
+	_ = interface{}(w.(io.Writer))
+}
+
+// Structs contains conversions between named and unnamed struct types.
+// A named struct type is never identical to its unnamed spelling, so
+// only conversions between identical unnamed types are reported.
+func Structs() {
+	type Point struct{ X, Y int }
+	type Other struct{ X, Y int }
+
+	var p Point
+	var o Other
+	var u struct{ X, Y int }
+	var v struct {
+		X int
+		Y int
+	}
+	var tagged struct {
+		X int `json:"x"`
+		Y int
+	}
+
+	_ = struct{ X, Y int }(u) //@ unnecessary conversion
+	_ = struct{ X, Y int }(v) //@ unnecessary conversion
+	_ = Point(p)              //@ unnecessary conversion
+
+	_ = Point(u)
+	_ = Point(o)
+	_ = struct{ X, Y int }(p)
+	_ = struct{ X, Y int }(tagged)
+}
+
+// Strings contains conversions to string. Only converting a string
+// to string is unnecessary; the others change the value's type.
+func Strings() {
+	var r rune
+	var b byte
+	var bs []byte
+	var rs []rune
+	var s string
+	var id ID
+
+	_ = string(s)          //@ unnecessary conversion
+	_ = string(s + s)      //@ unnecessary conversion
+	_ = string(s[1:])      //@ unnecessary conversion
+	_ = []byte(bs)         //@ unnecessary conversion
+	_ = []rune(rs)         //@ unnecessary conversion
+	_ = rune(r)            //@ unnecessary conversion
+	_ = string(string(id)) //@ unnecessary conversion
+
+	_ = string(r)
+	_ = string(b)
+	_ = string(bs)
+	_ = string(rs)
+	_ = string(id)
+	_ = []byte(s)
+	_ = []rune(s)
+	_ = string(rune(b))
+}
+
+// InitStmts contains conversions in the init statements of
+// if, for, and switch statements.
+func InitStmts() {
+	var n int
+	var id ID
+
+	for i := int(n); i < n; i++ { //@ unnecessary conversion
+	}
+	for i := 0; i < int(n); i++ { //@ unnecessary conversion
+	}
+	for i := 0; i < n; i = int(i + 1) { //@ unnecessary conversion
+	}
+	if x := ID(id); x != "" { //@ unnecessary conversion
+	}
+	if id := ID(id); ID(id) != "" { //@ unnecessary conversion //@ unnecessary conversion
+	}
+	switch x := int(n); x { //@ unnecessary conversion
+	}
+	switch x := int(n); ID(id) { //@ unnecessary conversion //@ unnecessary conversion
+	case ID(id): //@ unnecessary conversion
+		_ = x
+	}
+	switch y := interface{}(id).(type) {
+	case ID:
+		_ = y
+	}
+
+	for i := int64(n); i < 1; i++ {
+	}
+}
+
+// Returns contains conversions in return statements.
+func Returns() {
+	var id ID
+	var c Counter
+
+	_ = func() (ID, Counter) {
+		return ID(id), Counter(c) //@ unnecessary conversion //@ unnecessary conversion
+	}
+	_ = func() (x ID, y Counter) {
+		return ID(id), Counter(c) //@ unnecessary conversion //@ unnecessary conversion
+	}
+	_ = func() (ID, int64) {
+		return ID(string(id)), int64(c)
+	}
+	_ = func() (string, Counter) {
+		return string(id), Counter(c) //@ unnecessary conversion
+	}
+}
+
+// Operands contains conversions of the operands of binary expressions.
+func Operands() {
+	var a, b int
+	var c Counter
+	var p, q bool
+	var s string
+
+	_ = int(a) + b      //@ unnecessary conversion
+	_ = a * int(b)      //@ unnecessary conversion
+	_ = int(a) - int(b) //@ unnecessary conversion //@ unnecessary conversion
+	_ = a << uint(b)
+	_ = a << int(b)     //@ unnecessary conversion
+	_ = Counter(c) + 1  //@ unnecessary conversion
+	_ = int(a) < b      //@ unnecessary conversion
+	_ = int(a) == 1     //@ unnecessary conversion
+	_ = c != Counter(c) //@ unnecessary conversion
+	_ = bool(p) && q    //@ unnecessary conversion
+	_ = !bool(p) || q   //@ unnecessary conversion
+	_ = string(s) + "x" //@ unnecessary conversion
+
+	// The conversion gives the whole expression its type.
+	_ = Counter(1) + 2
+	_ = Counter(a) + c
+	_ = 1 + int64(a)
+	_ = float64(a) / 2
+	_ = int(1) << b
+}
+
+// Embedded contains conversions involving embedded and promoted fields.
+func Embedded() {
+	type Inner struct {
+		N Counter
+		S string
+	}
+	type Outer struct {
+		Inner
+		*Metric
+	}
+
+	var o Outer
+	var c Counter
+
+	_ = Counter(o.N)                       //@ unnecessary conversion
+	_ = Counter(o.Inner.N)                 //@ unnecessary conversion
+	_ = string(o.S)                        //@ unnecessary conversion
+	_ = ID(o.ID)                           //@ unnecessary conversion
+	_ = ID(o.Metric.ID)                    //@ unnecessary conversion
+	_ = Inner(o.Inner)                     //@ unnecessary conversion
+	_ = (*Metric)(o.Metric)                //@ unnecessary conversion
+	_ = Outer{Inner: Inner{N: Counter(c)}} //@ unnecessary conversion
+	_ = Outer{Inner: Inner(o.Inner)}       //@ unnecessary conversion
+	o.N = Counter(c)                       //@ unnecessary conversion
+
+	_ = int64(o.N)
+	_ = ID(o.S)
+	_ = Outer{Inner: Inner{N: Counter(len(o.S))}}
+}
+
+// Receives contains conversions of channel receive expressions.
+func Receives() {
+	var ch chan int
+	var ids <-chan ID
+	var cc chan Counter
+
+	_ = int(<-ch)       //@ unnecessary conversion
+	_ = ID(<-ids)       //@ unnecessary conversion
+	_ = Counter(<-cc)   //@ unnecessary conversion
+	_ = int((<-ch) + 1) //@ unnecessary conversion
+	if v, ok := <-cc; ok {
+		_ = Counter(v) //@ unnecessary conversion
+	}
+	select {
+	case v := <-ch:
+		_ = int(v) //@ unnecessary conversion
+	case cc <- Counter(<-cc): //@ unnecessary conversion
+	}
+
+	_ = int64(<-ch)
+	_ = string(<-ids)
+}
+
+// Arrays contains conversions in the elements of [...]T literals.
+func Arrays() {
+	var a, b int
+	var id ID
+
+	_ = [...]int{int(a), int(b)}  //@ unnecessary conversion //@ unnecessary conversion
+	_ = [...]int{0: a, 5: int(b)} //@ unnecessary conversion
+	_ = [...]ID{ID(id), "x"}      //@ unnecessary conversion
+	_ = [...][]int{{int(a)}}      //@ unnecessary conversion
+	_ = [...]*int{(*int)(&a)}     //@ unnecessary conversion
+	_ = len([...]int{int(a)})     //@ unnecessary conversion
+
+	_ = [...]int64{int64(a), int64(b)}
+	_ = [...]ID{ID("x")}
+	_ = [...]string{string(id)}
+}
+
+var _ = ID(ID(fmt.Sprint())) //@ unnecessary conversion
+
+// Discards contains conversions whose results are discarded, or that
+// appear in expression statements.
+func Discards() {
+	var a int
+	var id ID
+	var ch chan ID
+
+	_ = int(a)               //@ unnecessary conversion
+	_, _ = int(a), ID(id)    //@ unnecessary conversion //@ unnecessary conversion
+	var _ = ID(id)           //@ unnecessary conversion
+	var _, _ int = int(a), a //@ unnecessary conversion
+	fmt.Println(int(a))      //@ unnecessary conversion
+	ch <- ID(id)             //@ unnecessary conversion
+	id = ID(id)              //@ unnecessary conversion
+	a += int(a)              //@ unnecessary conversion
+	(func(ID))(nil)(ID(id))  //@ unnecessary conversion
+
+	_ = int64(a)
+	fmt.Println(int64(a))
+}
+
+// Level is a named type with a String method.
+type Level int
+
+func (l Level) String() string { return "level" }
+
+// Stringers contains conversions that strip or keep a String method.
+// Converting a Stringer to its underlying type changes how fmt prints
+// it, but those conversions change the type, so they're never
+// reported.
+func Stringers() {
+	var l Level
+	var n int
+
+	fmt.Printf("%d\n", int(l))
+	fmt.Printf("%v\n", int(l))
+	fmt.Println(fmt.Stringer(l))
+	fmt.Println(Level(n))
+
+	fmt.Printf("%d\n", int(n))   //@ unnecessary conversion
+	fmt.Printf("%v\n", Level(l)) //@ unnecessary conversion
+}
+
+// Labels contains conversions in labeled statements and around goto.
+func Labels(xs []int) {
+	var a int
+
+Outer:
+	for _, x := range xs {
+	Inner:
+		for i := int(x); i > 0; i-- { //@ unnecessary conversion
+			switch {
+			case int(i) == a: //@ unnecessary conversion
+				continue Outer
+			case int64(i) == 0:
+				break Inner
+			}
+		}
+	}
+
+Block:
+	{
+		a = int(a) + 1 //@ unnecessary conversion
+		if a < 10 {
+			goto Block
+		}
+	}
+
+	goto End
+End:
+	_ = int(a) //@ unnecessary conversion
+}
+
+// TypeSwitches contains conversions of type switch bindings, which
+// have a different type in each case clause.
+func TypeSwitches(v interface{}) {
+	switch x := v.(type) {
+	case int:
+		_ = int(x) //@ unnecessary conversion
+		_ = int64(x)
+	case ID:
+		_ = ID(x) //@ unnecessary conversion
+		_ = string(x)
+	case fmt.Stringer:
+		_ = fmt.Stringer(x) //@ unnecessary conversion
+		_ = interface{}(x)
+	case int32, int64:
+		// x has the type of v here.
+		_ = interface{}(x) //@ unnecessary conversion
+	case nil:
+		_ = interface{}(x) //@ unnecessary conversion
+	default:
+		_ = interface{}(x) //@ unnecessary conversion
+	}
+}
+
+// Conditions contains conversions of typed boolean variables in
+// conditions. Unlike conversions of untyped boolean expressions (see
+// regress.go), these are reported.
+func Conditions(b bool, n int) {
+	type B bool
+	var vb B
+
+	if bool(b) { //@ unnecessary conversion
+	}
+	if !bool(b) { //@ unnecessary conversion
+	}
+	for bool(b) { //@ unnecessary conversion
+		break
+	}
+	for i := 0; bool(b) && i < n; i++ { //@ unnecessary conversion
+	}
+	if B(vb) { //@ unnecessary conversion
+	}
+	if bool(vb) {
+	}
+	switch {
+	case bool(b): //@ unnecessary conversion
+	}
+
+	// Logical operators yield the type of their operands, but
+	// comparisons always have untyped boolean results.
+	if bool(b && n > 0) { //@ unnecessary conversion
+	}
+	if bool(n > 0) {
+	}
+	if bool(n > 0 || n < -1) {
+	}
+	if bool(true || false) {
+	}
+}
+
+// CompositeAddrs contains conversions of the addresses of composite
+// literals.
+func CompositeAddrs() {
+	type Alias = Metric
+	type Other Metric
+
+	_ = (*Metric)(&Metric{})            //@ unnecessary conversion
+	_ = (*Metric)(&Metric{ID: "id"})    //@ unnecessary conversion
+	_ = (*Alias)(&Metric{})             //@ unnecessary conversion
+	_ = []*Metric{(*Metric)(&Metric{})} //@ unnecessary conversion
+	if (*Metric)(&Metric{}) != nil {    //@ unnecessary conversion
+	}
+
+	_ = (*Other)(&Metric{})
+	_ = (*Metric)(&Other{})
+	_ = (*struct {
+		ID      ID
+		Counter Counter
+	})(&Metric{})
+}
+
+// Decls contains conversions in the initializers of var declarations
+// with explicit types.
+func Decls(n int, n64 int64, s string) {
+	var a int = int(n)                   //@ unnecessary conversion
+	var b string = string(s)             //@ unnecessary conversion
+	var c, d int = int(n), int(a)        //@ unnecessary conversion //@ unnecessary conversion
+	var e ID = ID(ID(s))                 //@ unnecessary conversion
+	var g interface{} = Metric(Metric{}) //@ unnecessary conversion
+	var (
+		h int = int(n) //@ unnecessary conversion
+		i ID  = ID(e)  //@ unnecessary conversion
+	)
+
+	// The declared type doesn't make a conversion unnecessary.
+	var j int64 = int64(n)
+	var k float64 = float64(n64)
+	var l ID = ID(s)
+	var m interface{} = int64(n)
+
+	// Nor do untyped constants, which the conversion gives a type.
+	var o float64 = float64(1)
+	var p int64 = int64(1 << 40)
+	var q uint8 = uint8('a')
+	var r byte = byte(10 % 7)
+	_, _, _, _, _, _, _, _, _, _, _, _, _, _, _, _ = a, b, c, d, e, g, h, i, j, k, l, m, o, p, q, r
+}
@@ -0,0 +1,39 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testdata
+
+// Kept marks its conversions as intentional.
+//
+//unconvert:keep
+type Kept int
+
+// Plain isn't marked.
+type Plain int
+
+type (
+	//unconvert:keep
+	GroupKept  int
+	GroupPlain int
+)
+
+// Conversions to types marked with //unconvert:keep aren't reported.
+func _() {
+	//unconvert:keep
+	type LocalKept string
+
+	var k Kept
+	var p Plain
+	var gk GroupKept
+	var gp GroupPlain
+	var lk LocalKept
+
+	_ = Kept(k)
+	_ = GroupKept(gk)
+	_ = LocalKept(lk)
+
+	_ = Plain(p)       //@ unnecessary conversion
+	_ = GroupPlain(gp) //@ unnecessary conversion
+	_ = (*Kept)(&k)    //@ unnecessary conversion
+}
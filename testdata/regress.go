@@ -50,3 +50,154 @@ func _() {
 	ctmp = c2 * c3
 	_ = c1 + complex128(ctmp)
 }
+
+// Make sure we don't remove conversions that box untyped
+// values into interfaces, which determine the dynamic type
+// of the interface value.
+func _() {
+	var e error
+	var a any
+
+	_ = interface{}(1)
+	_ = interface{}("s")
+	_ = interface{}(1.5)
+	_ = any(true)
+	_ = any('x')
+	_ = any(nil)
+	_ = error(nil)
+	_ = interface{}(nil)
+
+	e = error(nil)
+	a = any(nil)
+	a = any(1 << 3)
+	_, _ = e, a
+}
+
+// Make sure we don't remove conversions of nil that determine
+// the dynamic type stored in an interface.
+func _() {
+	type T struct{}
+	type E struct{ error }
+
+	var e error = (*E)(nil)
+	var a any = (*T)(nil)
+	e = error((*E)(nil))
+	a = any((*T)(nil))
+	a = (func())(nil)
+	a = []int(nil)
+	a = map[int]int(nil)
+	_, _ = e, a
+
+	var p *T
+	a = (*T)(p)           //@ unnecessary conversion
+	a = (*T)((*T)(nil))   //@ unnecessary conversion
+	e = error(error(nil)) //@ unnecessary conversion
+	_, _ = e, a
+}
+
+// Conversions in constant division and shift expressions fix the
+// type, and thus the precision, of the operation. Removing them would
+// change the constant's value, e.g. from 1/3.0 to integer 0.
+func _() {
+	const one = 1
+	const typed int = 7
+
+	const (
+		_ = float64(1) / 3
+		_ = float64(one) / 3
+		_ = 1 / float64(3)
+		_ = int(7) / 2
+		_ = int(one) / 2 * 2
+		_ = float32(1.0/3) * 3
+		_ = uint8(1) << 7 >> 7
+		_ = int32(-1) >> 1
+		_ = int64(1) << 40 / 3
+		_ = float64(typed) / 2
+	)
+
+	var x float64
+	_ = x * float64(1) / 3
+	_ = int64(1<<62) / 3
+}
+
+// Conversions of the predeclared untyped constants true, false, and
+// nil, and of iota, give them a type and can't be removed.
+func _() {
+	type B bool
+	type P *int
+
+	_ = bool(true)
+	_ = bool(false)
+	_ = (bool)(!true)
+	_ = B(true)
+	_ = bool(true == false)
+	_ = P(nil)
+	_ = (*int)(nil)
+	_ = []int(nil)
+	_ = map[string]int(nil)
+	_ = (func())(nil)
+	_ = chan int(nil)
+
+	const (
+		i0 = int(iota)
+		i1 = uint8(iota) << 1
+	)
+
+	var ok bool
+	_ = bool(ok) //@ unnecessary conversion
+}
+
+// Conversions of untyped constants in array lengths give the length
+// a type, and removing them would change or break the type, so
+// they're never reported.
+func _() {
+	const N = 4
+	const F = 4.0
+
+	var _ [int(N)]int
+	var _ [uint8(N)]byte
+	var _ [int(N) * 2]int
+	var _ [int(F)]int
+	var _ [int(N << 1)]int
+	_ = [int(N)]string{}
+	_ = [...]int{int(N): 1}
+	_ = len([int(N)]int{})
+
+	type A [uint(N) + 1]int
+	var _ A
+}
+
+// Bit flag enums convert untyped 1 << iota expressions to give each
+// flag its type, so the conversions can't be removed.
+type Flags uint32
+
+const (
+	FlagRead = Flags(1 << iota)
+	FlagWrite
+	FlagExec
+	_
+	FlagSticky
+
+	FlagNone  = Flags(0)
+	FlagLast  = Flags(1 << (iota - 1))
+	FlagMask  = Flags(1<<iota - 1)
+	FlagHigh  = Flags(1 << (31 - iota))
+	FlagOther = Flags(^uint32(0) >> iota)
+)
+
+type Mode uint8
+
+const (
+	ModeA Mode = Mode(1) << iota
+	ModeB
+	ModeC = Mode(1<<iota) | Mode(1<<(iota+1))
+	ModeD = Mode((1 << iota) >> 1)
+	ModeE = Mode(uint8(1) << iota)
+)
+
+func _(f Flags, m Mode) {
+	_ = f&Flags(1<<3) != 0
+	_ = m | Mode(1<<2)
+	_ = Flags(1 << m)
+	_ = Flags(f) //@ unnecessary conversion
+}
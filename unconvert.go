@@ -7,7 +7,11 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
 	"flag"
 	"fmt"
 	"go/ast"
@@ -15,14 +19,19 @@ import (
 	"go/parser"
 	"go/token"
 	"go/types"
+	"io"
 	"log"
 	"os"
 	"os/exec"
-	"reflect"
+	"path/filepath"
+	"regexp"
 	"runtime/pprof"
 	"sort"
 	"strings"
 	"sync"
+	"text/tabwriter"
+	"text/template"
+	"time"
 	"unicode"
 
 	"golang.org/x/text/width"
@@ -32,19 +41,75 @@ import (
 // Unnecessary conversions are identified by the position
 // of their left parenthesis within a source file.
 
-type editSet map[token.Position]struct{}
+type editSet map[token.Position]edit
 
-func (e editSet) add(pos token.Position) {
+// An edit holds details about an unnecessary conversion.
+// Its fields are exported so that it can be stored in the
+// results cache.
+type edit struct {
+	// ReportOnly indicates that the conversion should be
+	// reported, but not removed by -apply.
+	ReportOnly bool
+
+	// Text is the source text of the conversion expression.
+	Text string
+
+	// Type is the type of the conversion, which is identical
+	// to its operand's type.
+	Type string
+
+	// ArgType is the type of the operand. It's identical to
+	// Type, but may be spelled differently, like interface{}
+	// for any.
+	ArgType string
+
+	// Func is the name of the enclosing function declaration,
+	// if any, qualified by its receiver type for methods.
+	Func string
+
+	// Generated indicates that the conversion is in a
+	// generated file. Such conversions are only reported.
+	Generated bool
+
+	// Rule is the ID of the conversion's category; see rules.
+	Rule string
+}
+
+// A conversion is an unnecessary conversion to be reported.
+type conversion struct {
+	token.Position
+	edit
+
+	// file is the name the source is read from, since Filename
+	// is as reported, after -paths and -path-prefix-strip.
+	file string
+}
+
+// source returns the position of c in the file it's read from.
+func (c conversion) source() token.Position {
+	pos := c.Position
+	if c.file != "" {
+		pos.Filename = c.file
+	}
+	return pos
+}
+
+func (e editSet) add(pos token.Position, ed edit) {
 	pos.Offset = 0
-	e[pos] = struct{}{}
+	e[pos] = ed
 }
 
 func (e editSet) has(pos token.Position) bool {
-	pos.Offset = 0
-	_, ok := e[pos]
+	_, ok := e.get(pos)
 	return ok
 }
 
+func (e editSet) get(pos token.Position) (edit, bool) {
+	pos.Offset = 0
+	ed, ok := e[pos]
+	return ed, ok
+}
+
 func (e editSet) remove(pos token.Position) {
 	pos.Offset = 0
 	delete(e, pos)
@@ -59,74 +124,307 @@ func (e editSet) intersect(x editSet) {
 	}
 }
 
+// keepFirst removes all positions from e but the first.
+func (e editSet) keepFirst() {
+	var first token.Position
+	for pos := range e {
+		if first.Line == 0 || pos.Line < first.Line || pos.Line == first.Line && pos.Column < first.Column {
+			first = pos
+		}
+	}
+	for pos := range e {
+		if pos != first {
+			delete(e, pos)
+		}
+	}
+}
+
 type fileToEditSet map[string]editSet
 
-func apply(file string, edits editSet) {
+// apply removes the conversions in edits from file, and returns
+// those it had to keep.
+func apply(opts *options, file string, edits editSet) editSet {
 	if len(edits) == 0 {
-		return
+		return nil
 	}
 
-	fset := token.NewFileSet()
-	f, err := parser.ParseFile(fset, file, nil, parser.ParseComments)
+	src, err := os.ReadFile(file)
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
-	// Note: We modify edits during the walk.
-	v := editor{edits: edits, file: fset.File(f.Package)}
-	ast.Walk(&v, f)
-	if len(edits) != 0 {
-		log.Printf("%s: missing edits %s", file, edits)
+	buf, kept, err := applyEdits(file, src, edits, !opts.NoFormat)
+	if err != nil {
+		fatal(err)
 	}
 
 	// TODO(mdempsky): Write to temporary file and rename.
-	var buf bytes.Buffer
-	err = format.Node(&buf, fset, f)
+	err = os.WriteFile(file, buf, 0)
+	if err != nil {
+		fatal(err)
+	}
+	return kept
+}
+
+// applyEdits returns the source of file, given by src, with the
+// conversions in edits removed. Neither the file nor edits is
+// modified, so callers can decide how to persist or diff the result.
+// It also returns the edits for conversions that couldn't be removed,
+// which should be reported instead.
+func applyEdits(file string, src []byte, edits editSet, reformat bool) ([]byte, editSet, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, file, src, parser.ParseComments)
 	if err != nil {
-		log.Fatal(err)
+		return nil, nil, err
+	}
+
+	// Note: The editor removes edits as it finds them.
+	remaining := make(editSet, len(edits))
+	for pos, ed := range edits {
+		remaining.add(pos, ed)
+	}
+	v := editor{edits: remaining, kept: make(editSet), file: fset.File(f.Package), src: src}
+	ast.Walk(&v, f)
+	if len(remaining) != 0 {
+		log.Printf("%s: missing edits %v", file, remaining)
 	}
 
-	err = os.WriteFile(file, buf.Bytes(), 0)
+	// Rather than reprinting the whole syntax tree, splice the
+	// conversions out of the original source so that the rest of
+	// the file is left as the user wrote it. The result is then
+	// gofmt'd to fix up alignment on the affected lines, unless
+	// the repo uses some other formatter.
+	buf := v.splice()
+	if !reformat {
+		return buf, v.kept, nil
+	}
+	buf, err = format.Source(buf)
 	if err != nil {
-		log.Fatal(err)
+		return nil, nil, fmt.Errorf("%s: %v", file, err)
 	}
+	return buf, v.kept, nil
+}
+
+// A cut is a range of source bytes [start, end) to delete.
+type cut struct {
+	start, end int
 }
 
 type editor struct {
 	edits editSet
+	kept  editSet // edits that can't be applied
 	file  *token.File
+	src   []byte
+	cuts  []cut
+
+	// stack holds the nodes enclosing the current node. Removed
+	// conversions are recorded in removed so that their arguments
+	// can be checked against the context they'll end up in.
+	stack   []ast.Node
+	removed map[ast.Node]bool
 }
 
 func (e *editor) Visit(n ast.Node) ast.Visitor {
 	if n == nil {
+		e.stack = e.stack[:len(e.stack)-1]
 		return nil
 	}
-	v := reflect.ValueOf(n).Elem()
-	for i, n := 0, v.NumField(); i < n; i++ {
-		switch f := v.Field(i).Addr().Interface().(type) {
-		case *ast.Expr:
-			e.rewrite(f)
-		case *[]ast.Expr:
-			for i := range *f {
-				e.rewrite(&(*f)[i])
-			}
-		}
+	if call, ok := n.(*ast.CallExpr); ok {
+		e.rewrite(call)
 	}
+	e.stack = append(e.stack, n)
 	return e
 }
 
-func (e *editor) rewrite(f *ast.Expr) {
-	call, ok := (*f).(*ast.CallExpr)
+func (e *editor) rewrite(call *ast.CallExpr) {
+	pos := e.file.Position(call.Lparen)
+	ed, ok := e.edits.get(pos)
 	if !ok {
 		return
 	}
+	e.edits.remove(pos)
 
-	pos := e.file.Position(call.Lparen)
-	if !e.edits.has(pos) {
+	arg := call.Args[0]
+	start := e.file.Offset(call.Pos())
+	lparen := e.file.Offset(call.Lparen)
+	argStart := e.file.Offset(arg.Pos())
+	argEnd := e.file.Offset(arg.End())
+	rparen := e.file.Offset(call.Rparen)
+
+	lead, tail := e.src[lparen+1:argStart], e.src[argEnd:rparen]
+	if hasComment(tail) {
+		// There's no way to remove the closing parenthesis
+		// without also moving the comment, so only report it.
+		e.kept.add(pos, ed)
 		return
 	}
-	*f = call.Args[0]
-	e.edits.remove(pos)
+
+	// Find the node that the argument will become an operand of,
+	// skipping over any enclosing conversions that are also being
+	// removed.
+	var parent, child ast.Node = nil, call
+	for i := len(e.stack) - 1; i >= 0; i-- {
+		if !e.removed[e.stack[i]] {
+			parent = e.stack[i]
+			break
+		}
+		child = e.stack[i]
+	}
+	if e.removed == nil {
+		e.removed = make(map[ast.Node]bool)
+	}
+	e.removed[call] = true
+
+	// Remove the type and, unless the argument still needs them,
+	// the parentheses. Whitespace and trailing commas within the
+	// parentheses are removed too, but comments are kept.
+	parens := needsParens(arg, parent, child)
+	if hasComment(lead) && bytes.Contains(lead, nl) && e.startsResult(call) {
+		// Keep the parentheses, or the line break after the
+		// comment would end the return statement.
+		parens = true
+	}
+	if hasCompositeLit(arg) && e.inStmtHeader() {
+		// Keep the parentheses, or the composite literal's
+		// brace would be parsed as the start of the block.
+		parens = true
+	}
+	if parens {
+		e.cuts = append(e.cuts, cut{start, lparen})
+	} else {
+		e.cuts = append(e.cuts, cut{start, lparen + 1})
+	}
+	if !hasComment(lead) {
+		e.cuts = append(e.cuts, cut{lparen + 1, argStart})
+	}
+	if parens {
+		e.cuts = append(e.cuts, cut{argEnd, rparen})
+	} else {
+		e.cuts = append(e.cuts, cut{argEnd, rparen + 1})
+	}
+
+	// If the argument is itself parenthesized, remove those
+	// parentheses too when the expression within doesn't need
+	// them. Composite literals keep them, since they may be in
+	// a statement header, where braces would be ambiguous.
+	for !parens {
+		paren, ok := arg.(*ast.ParenExpr)
+		if !ok || hasCompositeLit(paren.X) || needsParens(paren.X, parent, child) {
+			break
+		}
+		lparen, rparen := e.file.Offset(paren.Lparen), e.file.Offset(paren.Rparen)
+		xStart, xEnd := e.file.Offset(paren.X.Pos()), e.file.Offset(paren.X.End())
+		if hasComment(e.src[lparen+1:xStart]) || hasComment(e.src[xEnd:rparen]) {
+			break
+		}
+		e.cuts = append(e.cuts, cut{lparen, xStart}, cut{xEnd, rparen + 1})
+		e.removed[paren] = true
+		arg = paren.X
+	}
+}
+
+// startsResult reports whether call is at the start of the results of
+// the innermost enclosing return statement.
+func (e *editor) startsResult(call *ast.CallExpr) bool {
+	for i := len(e.stack) - 1; i >= 0; i-- {
+		switch n := e.stack[i].(type) {
+		case *ast.ReturnStmt:
+			return n.Results[0].Pos() == call.Pos()
+		case *ast.FuncLit:
+			return false
+		}
+	}
+	return false
+}
+
+// inStmtHeader reports whether the current node is in the header of
+// an if, for, or switch statement.
+func (e *editor) inStmtHeader() bool {
+	for i := len(e.stack) - 1; i >= 0; i-- {
+		switch e.stack[i].(type) {
+		case *ast.IfStmt, *ast.ForStmt, *ast.RangeStmt, *ast.SwitchStmt, *ast.TypeSwitchStmt:
+			return true
+		case *ast.BlockStmt, *ast.FuncLit:
+			return false
+		}
+	}
+	return false
+}
+
+// hasCompositeLit reports whether x contains a composite literal.
+func hasCompositeLit(x ast.Expr) bool {
+	found := false
+	ast.Inspect(x, func(n ast.Node) bool {
+		if _, ok := n.(*ast.CompositeLit); ok {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// splice returns a copy of the source with all cuts removed.
+func (e *editor) splice() []byte {
+	sort.Slice(e.cuts, func(i, j int) bool {
+		// Cuts may be empty, so order ties by end too.
+		if e.cuts[i].start != e.cuts[j].start {
+			return e.cuts[i].start < e.cuts[j].start
+		}
+		return e.cuts[i].end < e.cuts[j].end
+	})
+
+	var buf bytes.Buffer
+	off := 0
+	for _, c := range e.cuts {
+		buf.Write(e.src[off:c.start])
+		off = c.end
+	}
+	buf.Write(e.src[off:])
+	return buf.Bytes()
+}
+
+// needsParens reports whether x needs to be parenthesized when it
+// replaces child as an operand of parent.
+func needsParens(x ast.Expr, parent, child ast.Node) bool {
+	var prec int
+	switch x := x.(type) {
+	case *ast.BinaryExpr:
+		prec = x.Op.Precedence()
+	case *ast.UnaryExpr, *ast.StarExpr:
+		prec = token.UnaryPrec
+	default:
+		// Primary expressions never need parentheses.
+		return false
+	}
+
+	switch p := parent.(type) {
+	case *ast.BinaryExpr:
+		// Conservatively keep parentheses around unary
+		// operands too, so that operators can't merge into
+		// a different token (e.g., "a - -b" into "a--b").
+		return prec <= p.Op.Precedence() || prec == token.UnaryPrec
+	case *ast.UnaryExpr, *ast.StarExpr:
+		return true
+	case *ast.SelectorExpr:
+		return p.X == child
+	case *ast.IndexExpr:
+		return p.X == child
+	case *ast.IndexListExpr:
+		return p.X == child
+	case *ast.SliceExpr:
+		return p.X == child
+	case *ast.TypeAssertExpr:
+		return p.X == child
+	case *ast.CallExpr:
+		return p.Fun == child
+	}
+	return false
+}
+
+// hasComment reports whether the whitespace and punctuation
+// between two tokens, given by b, contains a comment.
+func hasComment(b []byte) bool {
+	return bytes.Contains(b, []byte("//")) || bytes.Contains(b, []byte("/*"))
 }
 
 var (
@@ -134,24 +432,91 @@ var (
 	nl = []byte{'\n'}
 )
 
-func print(conversions []token.Position) {
-	var file string
-	var lines [][]byte
+// A finding describes an unnecessary conversion. Its fields are
+// available to -format templates.
+type finding struct {
+	File    string // file name
+	Line    int    // line number, starting at 1
+	Col     int    // column number, starting at 1 (byte count)
+	Snippet string // source line, without surrounding white space
+	Text    string // source text of the conversion
+	Type    string // type of the conversion and its operand
+	Rule    string // rule ID, like unconvert/basic
+
+	// Generated reports whether the conversion is in a
+	// generated file.
+	Generated bool
+
+	// Fingerprint identifies the finding across runs, even if
+	// unrelated edits move it to another line.
+	Fingerprint string
+}
 
-	for _, pos := range conversions {
-		fmt.Printf("%s:%d:%d: unnecessary conversion\n", pos.Filename, pos.Line, pos.Column)
-		if *flagV {
-			if pos.Filename != file {
-				buf, err := os.ReadFile(pos.Filename)
-				if err != nil {
-					log.Fatal(err)
-				}
-				file = pos.Filename
-				lines = bytes.Split(buf, nl)
+// print prints conversions to w in the format selected by opts.
+func print(w io.Writer, opts *options, conversions []conversion) {
+	if opts.F == "stylish" {
+		printStylish(w, conversions)
+		return
+	}
+	if opts.XML {
+		printCheckstyle(w, opts, conversions)
+		return
+	}
+	if opts.JSON {
+		printJSON(w, opts, conversions)
+		return
+	}
+
+	src := sourceFile{overlay: opts.Overlay}
+	var prints []string
+	if opts.Format != nil {
+		prints = fingerprints(conversions)
+	}
+
+	for i, pos := range conversions {
+		if opts.F == "text" {
+			fmt.Fprintf(w, "%s:%d:%d: unnecessary conversion (unconvert)\n", pos.Filename, pos.Line, pos.Column)
+			continue
+		}
+		if opts.Format != nil {
+			f := finding{
+				File:    pos.Filename,
+				Line:    pos.Line,
+				Col:     pos.Column,
+				Snippet: string(bytes.TrimSpace(src.line(pos.source()))),
+				Text:    pos.Text,
+				Type:    pos.Type,
+				Rule:    pos.Rule,
+
+				Generated:   pos.Generated,
+				Fingerprint: prints[i],
+			}
+			if err := opts.Format.Execute(w, f); err != nil {
+				fatal(err)
 			}
+			continue
+		}
 
-			line := bytes.TrimSuffix(lines[pos.Line-1], cr)
-			fmt.Printf("%s\n", line)
+		msg := "unnecessary conversion"
+		if pos.Generated {
+			msg += " (generated)"
+		}
+		msg += ": " + pos.Text
+		if opts.Explain {
+			msg += "; " + explain(pos.edit)
+		}
+		if opts.ShowTypes {
+			arg := pos.ArgType
+			if arg == "" {
+				// Merged -json reports only have Type.
+				arg = pos.Type
+			}
+			msg += fmt.Sprintf("; arg: %s, target: %s (identical)", arg, pos.Type)
+		}
+		fmt.Fprintf(w, "%s:%d:%d: %s\n", pos.Filename, pos.Line, pos.Column, msg)
+		if opts.Verbose {
+			line := src.line(pos.source())
+			fmt.Fprintf(w, "%s\n", line)
 
 			// For files processed by cgo, Column is the
 			// column location after cgo processing, which
@@ -160,12 +525,257 @@ func print(conversions []token.Position) {
 			// heuristic for detecting this case, at least
 			// avoid panicking if column is out of bounds.
 			if pos.Column <= len(line) {
-				fmt.Printf("%s^\n", rub(line[:pos.Column-1]))
+				fmt.Fprintf(w, "%s^\n", rub(line[:pos.Column-1]))
 			}
 		}
 	}
 }
 
+// explain returns a short explanation of why the conversion
+// described by ed is unnecessary.
+func explain(ed edit) string {
+	if _, ok := types.Universe.Lookup(ed.Type).(*types.TypeName); ok {
+		return fmt.Sprintf("argument is already %s", ed.Type)
+	}
+	return fmt.Sprintf("argument type %s is identical to target", ed.Type)
+}
+
+// fingerprints returns a fingerprint for each of the conversions,
+// which must be sorted by position. Fingerprints are computed from
+// the file's path relative to the working directory, the enclosing
+// function, and the conversion's text with white space normalized,
+// plus an index to tell apart identical conversions in the same
+// function. Line and column numbers aren't used, so fingerprints
+// are stable across edits elsewhere in the file.
+func fingerprints(conversions []conversion) []string {
+	wd, _ := os.Getwd()
+	seen := make(map[string]int)
+	res := make([]string, len(conversions))
+	for i, conv := range conversions {
+		file := conv.Filename
+		if abs, err := filepath.Abs(file); err == nil {
+			if rel, err := filepath.Rel(wd, abs); err == nil {
+				file = rel
+			}
+		}
+		id := fmt.Sprintf("%s\x00%s\x00%s", filepath.ToSlash(file), conv.Func, strings.Join(strings.Fields(conv.Text), " "))
+		n := seen[id]
+		seen[id]++
+
+		sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d", id, n)))
+		res[i] = hex.EncodeToString(sum[:16])
+	}
+	return res
+}
+
+// printStylish prints conversions to w grouped by file, like
+// staticcheck's stylish output format.
+func printStylish(w io.Writer, conversions []conversion) {
+	var file string
+	var tw *tabwriter.Writer
+
+	for _, pos := range conversions {
+		if pos.Filename != file {
+			if tw != nil {
+				tw.Flush()
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintln(w, pos.Filename)
+			file = pos.Filename
+			tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		}
+		fmt.Fprintf(tw, "  (%d, %d)\tunconvert\tunnecessary conversion\n", pos.Line, pos.Column)
+	}
+	if tw != nil {
+		tw.Flush()
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, " ✖ %d problems (%d errors, 0 warnings)\n", len(conversions), len(conversions))
+}
+
+// jsonVersion is the version of the -json output format. It must be
+// incremented for any change that may break existing consumers, like
+// removing or renaming a field or changing its meaning. New fields
+// may be added without changing the version.
+const jsonVersion = 1
+
+// A jsonOutput is the -json report.
+type jsonOutput struct {
+	Version  int           `json:"version"`
+	Findings []jsonFinding `json:"findings"`
+}
+
+type jsonFinding struct {
+	File        string `json:"file"`
+	Line        int    `json:"line"`
+	Col         int    `json:"col"`
+	Text        string `json:"text"`
+	Type        string `json:"type"`
+	Func        string `json:"func,omitempty"`
+	Rule        string `json:"rule"`
+	Generated   bool   `json:"generated,omitempty"`
+	Severity    string `json:"severity"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// printJSON prints conversions to w as a versioned JSON report.
+func printJSON(w io.Writer, opts *options, conversions []conversion) {
+	prints := fingerprints(conversions)
+	out := jsonOutput{Version: jsonVersion, Findings: []jsonFinding{}}
+	for i, pos := range conversions {
+		out.Findings = append(out.Findings, jsonFinding{
+			File:        pos.Filename,
+			Line:        pos.Line,
+			Col:         pos.Column,
+			Text:        pos.Text,
+			Type:        pos.Type,
+			Func:        pos.Func,
+			Rule:        pos.Rule,
+			Generated:   pos.Generated,
+			Severity:    opts.Severity,
+			Fingerprint: prints[i],
+		})
+	}
+
+	buf, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		fatal(err)
+	}
+	fmt.Fprintf(w, "%s\n", buf)
+}
+
+// A statsOutput is the -stats report.
+type statsOutput struct {
+	Files             int            `json:"files"`               // files analyzed
+	FilesWithFindings int            `json:"files_with_findings"` // files with unnecessary conversions
+	Findings          int            `json:"findings"`            // unnecessary conversions
+	Rules             map[string]int `json:"rules"`               // unnecessary conversions by rule ID
+	Elapsed           float64        `json:"elapsed_seconds"`     // time taken by the analysis
+}
+
+// printStats prints a summary of the conversions found in the files
+// of m as a JSON object.
+func printStats(m fileToEditSet, conversions []conversion, elapsed time.Duration) {
+	out := statsOutput{
+		Files:    len(m),
+		Findings: len(conversions),
+		Rules:    make(map[string]int),
+		Elapsed:  elapsed.Seconds(),
+	}
+	for _, rule := range rules {
+		out.Rules[rule] = 0
+	}
+	files := make(map[string]bool)
+	for _, pos := range conversions {
+		files[pos.Filename] = true
+		out.Rules[pos.Rule]++
+	}
+	out.FilesWithFindings = len(files)
+
+	buf, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		fatal(err)
+	}
+	fmt.Printf("%s\n", buf)
+}
+
+type checkstyleOutput struct {
+	XMLName xml.Name         `xml:"checkstyle"`
+	Version string           `xml:"version,attr"`
+	Files   []checkstyleFile `xml:"file"`
+}
+
+type checkstyleFile struct {
+	Name   string            `xml:"name,attr"`
+	Errors []checkstyleError `xml:"error"`
+}
+
+type checkstyleError struct {
+	Line     int    `xml:"line,attr"`
+	Column   int    `xml:"column,attr"`
+	Severity string `xml:"severity,attr"`
+	Message  string `xml:"message,attr"`
+	Source   string `xml:"source,attr"`
+}
+
+// printCheckstyle prints conversions to w as a Checkstyle XML report.
+func printCheckstyle(w io.Writer, opts *options, conversions []conversion) {
+	out := checkstyleOutput{Version: "5.0"}
+	for _, pos := range conversions {
+		if n := len(out.Files); n == 0 || out.Files[n-1].Name != pos.Filename {
+			out.Files = append(out.Files, checkstyleFile{Name: pos.Filename})
+		}
+		f := &out.Files[len(out.Files)-1]
+		message := "unnecessary conversion"
+		if pos.Generated {
+			message += " (generated)"
+		}
+		f.Errors = append(f.Errors, checkstyleError{
+			Line:     pos.Line,
+			Column:   pos.Column,
+			Severity: opts.Severity,
+			Message:  message,
+			Source:   pos.Rule,
+		})
+	}
+
+	buf, err := xml.MarshalIndent(out, "", "  ")
+	if err != nil {
+		fatal(err)
+	}
+	fmt.Fprintf(w, "%s%s\n", xml.Header, buf)
+}
+
+// A sourceFile holds the most recently read source file.
+type sourceFile struct {
+	overlay map[string][]byte
+	name    string
+	buf     []byte
+	lines   []int // offsets of the starts of the lines in buf, once needed
+}
+
+// read returns the contents of the named file.
+func (s *sourceFile) read(name string) []byte {
+	if name != s.name {
+		buf, err := readSource(s.overlay, name)
+		if err != nil {
+			fatal(err)
+		}
+		s.name, s.buf, s.lines = name, buf, nil
+	}
+	return s.buf
+}
+
+// line returns the source line containing pos, without its
+// line terminator.
+func (s *sourceFile) line(pos token.Position) []byte {
+	buf := s.read(pos.Filename)
+	if s.lines == nil {
+		s.lines = make([]int, 1, bytes.Count(buf, nl)+1)
+		for i, b := range buf {
+			if b == '\n' {
+				s.lines = append(s.lines, i+1)
+			}
+		}
+	}
+	line := buf[s.lines[pos.Line-1]:]
+	if pos.Line < len(s.lines) {
+		line = line[:s.lines[pos.Line]-s.lines[pos.Line-1]-1]
+	}
+	return bytes.TrimSuffix(line, cr)
+}
+
+// readSource returns the contents of the named file, preferring
+// those in overlay, which is keyed by absolute path.
+func readSource(overlay map[string][]byte, name string) ([]byte, error) {
+	if abs, err := filepath.Abs(name); err == nil {
+		if src, ok := overlay[abs]; ok {
+			return src, nil
+		}
+	}
+	return os.ReadFile(name)
+}
+
 // Rub returns a copy of buf with all non-whitespace characters replaced
 // by spaces (like rubbing them out with white out).
 func rub(buf []byte) []byte {
@@ -197,87 +807,788 @@ var (
 	flagFastMath = flag.Bool("fastmath", false, "remove conversions that force intermediate rounding")
 	flagTags     = flag.String("tags", "", "a space-separated list of build tags to consider satisfied during the build")
 	flagConfigs  = flag.String("configs", "", "custom configs to run unconvert (experimental)")
+	flagInclude  = flag.String("include", "", "comma-separated list of file glob patterns to restrict results to")
+	flagFiles    = flag.String("files", "", "comma-separated list of files to restrict results to")
+	flagNoCgo    = flag.Bool("no-cgo", false, "disable cgo and skip files that import \"C\"")
+	flagCount    = flag.Bool("count", false, "only print the number of unnecessary conversions")
+	flagInlining = flag.Bool("keep-inlining-hints", false, "keep conversions of function values that are immediately called (best effort)")
+	flagFormat   = flag.String("format", "", "print each finding using the given `template`; fields are .File, .Line, .Col, .Snippet, .Text, .Type, .Rule, and .Fingerprint")
+	flagF        = flag.String("f", "", "print findings in a staticcheck-compatible `format`: text or stylish")
+	flagStrict   = flag.Bool("strict", false, "with -apply, only report conversions of expressions that may have side effects")
+	flagCacheDir = flag.String("cache-dir", "", "cache results in `dir` and reuse them for unchanged packages")
+	flagDeps     = flag.Bool("deps", false, "also analyze dependencies in the main module")
+	flagPrefix   = flag.String("deps-prefix", "", "with -deps, analyze dependencies whose package path has this `prefix` instead")
+	flagUntyped  = flag.Bool("strict-untyped", false, "never report conversions of constant expressions")
+	flagXML      = flag.Bool("checkstyle", false, "print findings as a Checkstyle XML report")
+	flagOnlySafe = flag.Bool("rewrite-only-safe", false, "with -apply, only remove conversions of non-constant identifiers and selectors")
+	flagPaths    = flag.String("paths", "", "print file paths as `relative` or absolute (default as given by the go command)")
+	flagQuiet    = flag.Bool("q", false, "print nothing; only set the exit status")
+	flagTypes    = flag.Bool("show-types", false, "print the argument and target types of each finding")
+	flagModFile  = flag.String("modfile", "", "use the given go.mod `file` instead of the one in the module root")
+	flagExported = flag.Bool("exported-only", false, "only report conversions in exported declarations")
+	flagSeverity = flag.String("severity", "warning", "severity `level` of findings in structured reports: info, warning, or error")
+	flagRoot     = flag.String("root", "", "change to `dir` before loading packages; other paths are relative to it")
+	flagExplain  = flag.Bool("explain", false, "explain why each conversion is unnecessary")
+	flagGen      = flag.String("generated", "include", "`mode` for generated files: include, skip, or report (report but never apply)")
+	flagTimeout  = flag.Duration("timeout", 0, "give up after `duration` and report the findings so far (0 means no limit)")
+	flagJSON     = flag.Bool("json", false, "print findings as a versioned JSON report")
+	flagStdout   = flag.String("apply-stdout", "", "apply edits to `file` and print the result, instead of writing it back")
+	flagNoFormat = flag.Bool("no-apply-format", false, "with -apply, only remove the conversions, without running gofmt on the result")
+	flagStrip    = flag.String("path-prefix-strip", "", "strip `prefix` from the file paths of findings")
+	flagStdin    = flag.String("stdin-file", "", "read the source of `file` from standard input, and only report findings in it")
+	flagVendor   = flag.String("vendor", "skip", "`mode` for files in vendor directories: skip, or report (report but never apply)")
+	flagFirst    = flag.Bool("first-only", false, "report at most one unnecessary conversion per file")
+	flagPatchDir = flag.String("patch-dir", "", "instead of applying edits, write them as patches to `dir`, one per source directory")
+	flagOut      = flag.String("o", "", "write findings in the format selected by -json, -checkstyle, -f, or -format to `file`, and print them in the default format")
+	flagPrint    = flag.Bool("config-print", false, "print the effective settings and package patterns as JSON, and exit")
+	flagIgnore   = flag.String("ignore-pkg", "", "comma-separated list of package `paths` whose types' conversions are never reported")
+	flagStats    = flag.Bool("stats", false, "only print a JSON summary of the files analyzed and unnecessary conversions found")
+	flagReach    = flag.Bool("reachable", false, "only report conversions in main packages and the packages they import")
+	flagMerge    = flag.Bool("merge", false, "instead of analyzing packages, print the findings in the given -json report files, without duplicates")
+	flagQuietLd  = flag.Bool("quiet-load", false, "don't print errors in packages, like type errors, and don't fail because of them")
+	flagScript   = flag.String("script", "", "instead of applying edits, write them to `file` as a shell script that applies them with patch")
+	flagGoList   = flag.String("golist", "", "load packages from `file`, the output of go list -deps -json, instead of running go list")
+	flagDisable  = flag.String("disable", "", "comma-separated list of `rules` not to report, like unconvert/alias,unconvert/const")
+)
+
+// flagFrom lists the files given to -from.
+var flagFrom listFlag
+
+// A listFlag is a flag that may be repeated to give a list of values.
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, ",") }
+
+func (l *listFlag) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+func init() {
+	flag.BoolVar(flagQuiet, "quiet", false, "same as -q")
+	flag.Var(&flagFrom, "from", "also read package patterns from `file`, one per line; may be repeated")
+
+	// Aliases for -apply, like other Go tools.
+	flag.BoolVar(flagApply, "fix", false, "same as -apply")
+	flag.BoolVar(flagApply, "w", false, "same as -apply")
+}
+
+// Exit statuses, like those of diff and grep.
+const (
+	exitFindings = 1 // unnecessary conversions were found
+	exitUsage    = 2 // the command line was invalid
+	exitError    = 3 // packages failed to load, or something else went wrong
 )
 
 func usage() {
 	fmt.Fprintf(os.Stderr, "usage: unconvert [flags] [package ...]\n")
 	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\nThe exit status is 0 if no unnecessary conversions were found, %d if\n", exitFindings)
+	fmt.Fprintf(os.Stderr, "some were, %d for usage errors, and %d if packages failed to load or\n", exitUsage, exitError)
+	fmt.Fprintf(os.Stderr, "another error occurred.\n")
+}
+
+// fatal is like log.Fatal, but exits with status exitError.
+func fatal(v ...any) {
+	log.Print(v...)
+	exit(exitError)
+}
+
+// exit exits with the given status, after stopping the CPU profile
+// so that it isn't lost.
+func exit(code int) {
+	pprof.StopCPUProfile()
+	os.Exit(code)
 }
 
 func main() {
+	if file, ok := goListDriverFile(); ok {
+		// We're the go/packages driver for -golist.
+		if err := goListDriver(file); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
 	flag.Usage = usage
 	flag.Parse()
 
+	if *flagRoot != "" {
+		// Like the go command's -C flag, this affects how
+		// everything else is interpreted, so do it first.
+		if err := os.Chdir(*flagRoot); err != nil {
+			fatal(err)
+		}
+	}
+
 	if *flagCPUProfile != "" {
 		f, err := os.Create(*flagCPUProfile)
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 		pprof.StartCPUProfile(f)
 		defer pprof.StopCPUProfile()
 	}
 
-	patterns := flag.Args() // 0 or more import path patterns.
+	switch *flagPaths {
+	case "", "relative", "absolute":
+	default:
+		fmt.Fprintf(os.Stderr, "invalid -paths value %q\n", *flagPaths)
+		usage()
+		exit(exitUsage)
+	}
 
-	var configs [][]string
-	if *flagConfigs != "" {
-		if os.Getenv("UNCONVERT_CONFIGS_EXPERIMENT") != "1" {
-			fmt.Println("WARNING: -configs is experimental and subject to change without notice.")
-			fmt.Println("Please comment at https://github.com/mdempsky/unconvert/issues/26")
-			fmt.Println("if you'd like to rely on this interface.")
-			fmt.Println("(Set UNCONVERT_CONFIGS_EXPERIMENT=1 to silence this warning.)")
-			fmt.Println()
-		}
+	switch *flagGen {
+	case "include", "skip", "report":
+	default:
+		fmt.Fprintf(os.Stderr, "invalid -generated value %q\n", *flagGen)
+		usage()
+		exit(exitUsage)
+	}
 
-		if err := json.Unmarshal([]byte(*flagConfigs), &configs); err != nil {
-			log.Fatal(err)
-		}
-	} else if *flagAll {
-		configs = allConfigs()
-	} else {
-		configs = [][]string{nil}
+	switch *flagVendor {
+	case "skip", "report":
+	default:
+		fmt.Fprintf(os.Stderr, "invalid -vendor value %q\n", *flagVendor)
+		usage()
+		exit(exitUsage)
 	}
 
-	m := mergeEdits(patterns, configs)
+	switch *flagSeverity {
+	case "info", "warning", "error":
+	default:
+		fmt.Fprintf(os.Stderr, "invalid -severity value %q\n", *flagSeverity)
+		usage()
+		exit(exitUsage)
+	}
 
-	if *flagApply {
-		var wg sync.WaitGroup
-		for f, e := range m {
-			wg.Add(1)
-			f, e := f, e
-			go func() {
-				defer wg.Done()
-				apply(f, e)
-			}()
-		}
-		wg.Wait()
-	} else {
-		var conversions []token.Position
-		for _, positions := range m {
-			for pos := range positions {
-				conversions = append(conversions, pos)
-			}
-		}
-		sort.Sort(byPosition(conversions))
-		print(conversions)
-		if len(conversions) > 0 {
-			os.Exit(1)
+	for _, rule := range strings.Split(*flagDisable, ",") {
+		if rule != "" && !isRule(rule) {
+			fmt.Fprintf(os.Stderr, "invalid -disable rule %q; rules are %s\n", rule, strings.Join(rules, ", "))
+			usage()
+			exit(exitUsage)
 		}
 	}
-}
 
-func allConfigs() [][]string {
-	out, err := exec.Command("go", "tool", "dist", "list", "-json").Output()
-	if err != nil {
-		log.Fatal(err)
+	switch *flagF {
+	case "", "text", "stylish":
+	default:
+		fmt.Fprintf(os.Stderr, "invalid -f value %q\n", *flagF)
+		usage()
+		exit(exitUsage)
 	}
-
-	var platforms []struct {
-		GOOS, GOARCH string
+	formats := 0
+	for _, set := range []bool{*flagF != "", *flagFormat != "", *flagXML, *flagJSON} {
+		if set {
+			formats++
+		}
+	}
+	if formats > 1 {
+		fmt.Fprintf(os.Stderr, "-f, -format, -checkstyle, and -json are mutually exclusive\n")
+		usage()
+		exit(exitUsage)
+	}
+
+	edits := 0
+	for _, set := range []bool{*flagApply, *flagPatchDir != "", *flagScript != ""} {
+		if set {
+			edits++
+		}
+	}
+	if edits > 1 {
+		fmt.Fprintf(os.Stderr, "-apply, -patch-dir, and -script are mutually exclusive\n")
+		usage()
+		exit(exitUsage)
+	}
+
+	opts := optionsFromFlags()
+
+	if *flagFormat != "" {
+		tmpl, err := template.New("format").Parse(*flagFormat + "\n")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -format template: %v\n", err)
+			exit(exitUsage)
+		}
+		opts.Format = tmpl
+	}
+
+	if opts.Merge {
+		mergeMain(opts)
+		return
+	}
+
+	args := flag.Args()
+	for _, file := range flagFrom {
+		buf, err := os.ReadFile(file)
+		if err != nil {
+			fatal(err)
+		}
+		args = append(args, readPatterns(buf)...)
+	}
+	patterns := expandPatterns(dedup(args)) // 0 or more import path patterns.
+
+	if *flagGoList != "" {
+		if len(patterns) != 0 {
+			fmt.Fprintf(os.Stderr, "-golist cannot be used with package patterns\n")
+			usage()
+			exit(exitUsage)
+		}
+		if *flagAll || *flagConfigs != "" {
+			fmt.Fprintf(os.Stderr, "-golist cannot be used with -all or -configs\n")
+			usage()
+			exit(exitUsage)
+		}
+		abs, err := filepath.Abs(*flagGoList)
+		if err != nil {
+			fatal(err)
+		}
+		opts.GoList = abs
+	}
+
+	if *flagStdin != "" {
+		if opts.Apply {
+			fmt.Fprintf(os.Stderr, "-apply cannot be used with -stdin-file\n")
+			usage()
+			exit(exitUsage)
+		}
+		abs, err := filepath.Abs(*flagStdin)
+		if err != nil {
+			fatal(err)
+		}
+		src, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fatal(err)
+		}
+		opts.Overlay = map[string][]byte{abs: src}
+		if len(patterns) == 0 {
+			// Analyze the file in the context of
+			// the package in its directory.
+			patterns = []string{filepath.Dir(abs)}
+		}
+	}
+
+	if opts.ApplyStdout {
+		if opts.Apply {
+			fmt.Fprintf(os.Stderr, "-apply cannot be used with -apply-stdout\n")
+			usage()
+			exit(exitUsage)
+		}
+		abs, err := filepath.Abs(opts.OnlyFile)
+		if err != nil {
+			fatal(err)
+		}
+		if len(patterns) == 0 {
+			patterns = []string{filepath.Dir(abs)}
+		}
+	}
+
+	if *flagConfigs != "" {
+		if os.Getenv("UNCONVERT_CONFIGS_EXPERIMENT") != "1" {
+			fmt.Fprintln(os.Stderr, "WARNING: -configs is experimental and subject to change without notice.")
+			fmt.Fprintln(os.Stderr, "Please comment at https://github.com/mdempsky/unconvert/issues/26")
+			fmt.Fprintln(os.Stderr, "if you'd like to rely on this interface.")
+			fmt.Fprintln(os.Stderr, "(Set UNCONVERT_CONFIGS_EXPERIMENT=1 to silence this warning.)")
+			fmt.Fprintln(os.Stderr)
+		}
+
+		if err := json.Unmarshal([]byte(*flagConfigs), &opts.Configs); err != nil {
+			fatal(err)
+		}
+	} else if *flagAll {
+		opts.Configs = allConfigs()
+	} else {
+		opts.Configs = [][]string{nil}
+	}
+
+	if *flagPrint {
+		if err := printOptions(os.Stdout, opts, patterns); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	ctx := context.Background()
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	m, loadErrors := mergeEdits(ctx, opts, patterns)
+	elapsed := time.Since(start)
+
+	// If the analysis timed out or some packages failed to load,
+	// report what was found anyway, but fail even if that's
+	// nothing.
+	incomplete := ctx.Err() != nil || loadErrors > 0
+	if ctx.Err() != nil {
+		fmt.Fprintf(os.Stderr, "unconvert: timed out after %v; results are incomplete\n", opts.Timeout)
+	}
+
+	if opts.ApplyStdout {
+		// The file goes to standard output, so what's left
+		// in it is reported on standard error.
+		conversions := conversionsOf(opts, applyStdout(opts, opts.OnlyFile, m))
+		print(os.Stderr, opts, conversions)
+		if incomplete {
+			exit(exitError)
+		}
+		if len(conversions) > 0 {
+			exit(exitFindings)
+		}
+		return
+	}
+
+	if opts.Apply {
+		// Apply what we can; anything left over is
+		// reported below instead.
+		m = applyAll(opts, m)
+	} else if opts.PatchDir != "" {
+		m = writePatches(opts, opts.PatchDir, m)
+	} else if opts.Script != "" {
+		m = writeScript(opts, opts.Script, m)
+	}
+
+	printResults(opts, m, conversionsOf(opts, m), elapsed, incomplete)
+}
+
+// conversionsOf returns the conversions in m, sorted by position,
+// with their file names as they're to be reported.
+func conversionsOf(opts *options, m fileToEditSet) []conversion {
+	var conversions []conversion
+	for _, edits := range m {
+		for pos, ed := range edits {
+			file := pos.Filename
+			pos.Filename = normalizePath(opts, file)
+			conversions = append(conversions, conversion{pos, ed, file})
+		}
+	}
+	sort.Sort(byPosition(conversions))
+	return conversions
+}
+
+// printResults prints the conversions found, in the files of m, as
+// opts ask, and exits with the matching status. If the results
+// are incomplete, it exits with exitError even if there are none.
+func printResults(opts *options, m fileToEditSet, conversions []conversion, elapsed time.Duration, incomplete bool) {
+	if opts.Out != "" {
+		// Write the report in the selected format to the file,
+		// and print the default format for people instead.
+		f, err := os.Create(opts.Out)
+		if err != nil {
+			fatal(err)
+		}
+		print(f, opts, conversions)
+		if err := f.Close(); err != nil {
+			fatal(err)
+		}
+		human := *opts
+		human.F, human.Format, human.XML, human.JSON = "", nil, false, false
+		opts = &human
+	}
+
+	if opts.Stats && !opts.Quiet {
+		printStats(m, conversions, elapsed)
+		if incomplete {
+			exit(exitError)
+		}
+		return
+	}
+	if opts.Count && !opts.Quiet {
+		fmt.Println(len(conversions))
+		if incomplete {
+			exit(exitError)
+		}
+		return
+	}
+	if !opts.Quiet {
+		print(os.Stdout, opts, conversions)
+	}
+	if incomplete {
+		exit(exitError)
+	}
+	if len(conversions) > 0 {
+		exit(exitFindings)
+	}
+}
+
+// mergeMain is main for -merge: it prints the findings in the -json
+// reports named by the arguments as if they had been found by one
+// run.
+func mergeMain(opts *options) {
+	if flag.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "-merge needs -json report files to merge\n")
+		usage()
+		exit(exitUsage)
+	}
+	if opts.Apply || opts.PatchDir != "" || opts.Script != "" || opts.Stats {
+		fmt.Fprintf(os.Stderr, "-merge cannot be used with -apply, -patch-dir, -script, or -stats\n")
+		usage()
+		exit(exitUsage)
+	}
+
+	conversions, err := mergeReports(flag.Args())
+	if err != nil {
+		fatal(err)
+	}
+	for i := range conversions {
+		conversions[i].file = conversions[i].Filename
+		conversions[i].Filename = normalizePath(opts, conversions[i].Filename)
+	}
+	sort.Sort(byPosition(conversions))
+
+	printResults(opts, nil, conversions, 0, false)
+}
+
+// applyStdout applies the edits in m for file, and writes the
+// result to standard output instead of back to the file. Like
+// applyAll, it returns the edits for file that are only to be
+// reported, along with those it had to keep.
+func applyStdout(opts *options, file string, m fileToEditSet) fileToEditSet {
+	file, err := filepath.Abs(file)
+	if err != nil {
+		fatal(err)
+	}
+
+	report := make(fileToEditSet)
+	edits := make(editSet)
+	for f, e := range m {
+		if !listsFile(file, f) {
+			continue
+		}
+		for pos, ed := range e {
+			if ed.ReportOnly {
+				if report[f] == nil {
+					report[f] = make(editSet)
+				}
+				report[f].add(pos, ed)
+			} else {
+				edits.add(pos, ed)
+			}
+		}
+	}
+
+	src, err := readSource(opts.Overlay, file)
+	if err != nil {
+		fatal(err)
+	}
+	if len(edits) != 0 {
+		var kept editSet
+		src, kept, err = applyEdits(file, src, edits, !opts.NoFormat)
+		if err != nil {
+			fatal(err)
+		}
+		for pos, ed := range kept {
+			if report[pos.Filename] == nil {
+				report[pos.Filename] = make(editSet)
+			}
+			report[pos.Filename].add(pos, ed)
+		}
+	}
+	if _, err := os.Stdout.Write(src); err != nil {
+		fatal(err)
+	}
+	return report
+}
+
+// applyAll applies the edits in m to their files and returns
+// the edits that are only to be reported, along with those that
+// couldn't be applied.
+func applyAll(opts *options, m fileToEditSet) fileToEditSet {
+	report := make(fileToEditSet)
+
+	var mu sync.Mutex // guards keptBy
+	keptBy := make(fileToEditSet)
+	var wg sync.WaitGroup
+	for f, e := range m {
+		removable := make(editSet)
+		for pos, ed := range e {
+			if ed.ReportOnly {
+				if report[f] == nil {
+					report[f] = make(editSet)
+				}
+				report[f].add(pos, ed)
+			} else {
+				removable.add(pos, ed)
+			}
+		}
+
+		wg.Add(1)
+		f := f
+		go func() {
+			defer wg.Done()
+			kept := apply(opts, f, removable)
+			mu.Lock()
+			keptBy[f] = kept
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	// Conversions that couldn't be removed are reported.
+	for f, kept := range keptBy {
+		for pos, ed := range kept {
+			if report[f] == nil {
+				report[f] = make(editSet)
+			}
+			report[f].add(pos, ed)
+		}
+	}
+
+	return report
+}
+
+// A fileDiff is a unified diff of the edits to a file.
+type fileDiff struct {
+	name string // relative to the current directory
+	diff []byte
+}
+
+// diffEdits returns unified diffs of the edits in m, sorted by file,
+// instead of applying them. Like applyAll, it also returns the edits
+// that are only to be reported or couldn't be applied.
+func diffEdits(opts *options, m fileToEditSet) ([]fileDiff, fileToEditSet) {
+	report := make(fileToEditSet)
+	var diffs []fileDiff
+
+	files := make([]string, 0, len(m))
+	for f := range m {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	for _, f := range files {
+		removable := make(editSet)
+		for pos, ed := range m[f] {
+			if ed.ReportOnly {
+				if report[f] == nil {
+					report[f] = make(editSet)
+				}
+				report[f].add(pos, ed)
+			} else {
+				removable.add(pos, ed)
+			}
+		}
+		if len(removable) == 0 {
+			continue
+		}
+
+		src, err := readSource(opts.Overlay, f)
+		if err != nil {
+			fatal(err)
+		}
+		buf, kept, err := applyEdits(f, src, removable, !opts.NoFormat)
+		if err != nil {
+			fatal(err)
+		}
+		for pos, ed := range kept {
+			if report[f] == nil {
+				report[f] = make(editSet)
+			}
+			report[f].add(pos, ed)
+		}
+
+		// Diffs name files relative to the current directory,
+		// where they should be applied.
+		name := f
+		if filepath.IsAbs(name) {
+			wd, err := os.Getwd()
+			if err != nil {
+				fatal(err)
+			}
+			if name, err = filepath.Rel(wd, f); err != nil {
+				fatal(err)
+			}
+		}
+		if !filepath.IsLocal(name) {
+			fatal(fmt.Errorf("%s: can't write a patch for a file outside the current directory", f))
+		}
+		diffs = append(diffs, fileDiff{name, unifiedDiff(filepath.ToSlash(name), src, buf)})
+	}
+	return diffs, report
+}
+
+// writePatches writes the edits in m as unified diffs to dir, in one
+// patch file per source directory named like dir/pkg/unconvert.patch,
+// instead of applying them. Like applyAll, it returns the edits that
+// are only to be reported.
+func writePatches(opts *options, dir string, m fileToEditSet) fileToEditSet {
+	diffs, report := diffEdits(opts, m)
+
+	patches := make(map[string][]byte)
+	for _, d := range diffs {
+		pkg := filepath.Dir(d.name)
+		patches[pkg] = append(patches[pkg], d.diff...)
+	}
+	for pkg, patch := range patches {
+		file := filepath.Join(dir, pkg, "unconvert.patch")
+		if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+			fatal(err)
+		}
+		if err := os.WriteFile(file, patch, 0666); err != nil {
+			fatal(err)
+		}
+	}
+	return report
+}
+
+// scriptHeader starts the scripts written by writeScript.
+const scriptHeader = `#!/bin/sh
+# Removes unnecessary conversions found by unconvert. Run it from the
+# directory unconvert was run in. If the lines it edits, or the lines
+# around them, have changed since, it fails without changing anything.
+set -e
+diff=$(cat <<'UNCONVERT_EOF'
+`
+
+// scriptFooter ends the scripts written by writeScript.
+const scriptFooter = `UNCONVERT_EOF
+)
+printf '%s\n' "$diff" | patch -p1 -N -F 0 -s --dry-run
+printf '%s\n' "$diff" | patch -p1 -N -F 0
+`
+
+// writeScript writes the edits in m to file as a shell script that
+// applies them with patch, instead of applying them. The diff lines
+// all start with ' ', '+', '-', '@', or '\', so they can't end the
+// here-document early. Like applyAll, it returns the edits that are
+// only to be reported.
+func writeScript(opts *options, file string, m fileToEditSet) fileToEditSet {
+	diffs, report := diffEdits(opts, m)
+
+	var buf bytes.Buffer
+	buf.WriteString(scriptHeader)
+	for _, d := range diffs {
+		buf.Write(d.diff)
+	}
+	buf.WriteString(scriptFooter)
+	if err := os.WriteFile(file, buf.Bytes(), 0777); err != nil {
+		fatal(err)
+	}
+	return report
+}
+
+// readPatterns returns the package patterns listed in buf, one per
+// line. Blank lines and lines starting with # are ignored.
+func readPatterns(buf []byte) []string {
+	var res []string
+	for _, line := range strings.Split(string(buf), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		res = append(res, line)
+	}
+	return res
+}
+
+// dedup returns list without duplicates, keeping the first of each.
+func dedup(list []string) []string {
+	seen := make(map[string]bool)
+	var res []string
+	for _, s := range list {
+		if !seen[s] {
+			seen[s] = true
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// expandPatterns expands glob patterns in file system path arguments
+// (like ./cmd/*) to the matching directories, for shells that don't
+// expand them. Other patterns are returned unchanged.
+func expandPatterns(patterns []string) []string {
+	var res []string
+	for _, pattern := range patterns {
+		if !isPathPattern(pattern) || !strings.ContainsAny(pattern, "*?[") || strings.Contains(pattern, "...") {
+			res = append(res, pattern)
+			continue
+		}
+
+		matches, _ := filepath.Glob(pattern)
+		var dirs []string
+		for _, match := range matches {
+			if fi, err := os.Stat(match); err != nil || !fi.IsDir() {
+				continue
+			}
+			// filepath.Glob cleans away the leading "./",
+			// but the go command needs it to tell a
+			// directory apart from an import path.
+			if !filepath.IsAbs(match) && !strings.HasPrefix(match, ".") {
+				match = "." + string(filepath.Separator) + match
+			}
+			dirs = append(dirs, match)
+		}
+		if len(dirs) == 0 {
+			// Let the loader report the problem.
+			res = append(res, pattern)
+			continue
+		}
+		res = append(res, dirs...)
+	}
+	return res
+}
+
+// isPathPattern reports whether pattern is a file system path,
+// rather than an import path.
+func isPathPattern(pattern string) bool {
+	return filepath.IsAbs(pattern) || pattern == "." || pattern == ".." ||
+		strings.HasPrefix(pattern, "./") || strings.HasPrefix(pattern, "../") ||
+		strings.HasPrefix(pattern, `.\`) || strings.HasPrefix(pattern, `..\`)
+}
+
+// normalizePath returns filename as a relative or absolute path,
+// as requested by opts.Paths, and with opts.PathStrip removed.
+func normalizePath(opts *options, filename string) string {
+	filename = convertPath(opts.Paths, filename)
+	if opts.PathStrip != "" {
+		// Match whole path elements only. A cleaned prefix
+		// ends in a separator only if it's a root, like "/".
+		prefix := filepath.Clean(opts.PathStrip)
+		if rest, ok := strings.CutPrefix(filename, prefix); ok && (rest == "" || os.IsPathSeparator(rest[0]) || os.IsPathSeparator(prefix[len(prefix)-1])) {
+			filename = strings.TrimLeft(rest, string(filepath.Separator))
+		}
+	}
+	return filename
+}
+
+// convertPath returns filename as a relative or absolute path,
+// as requested by paths (see options.Paths).
+func convertPath(paths, filename string) string {
+	switch paths {
+	case "relative":
+		wd, err := os.Getwd()
+		if err != nil {
+			return filename
+		}
+		abs, err := filepath.Abs(filename)
+		if err != nil {
+			return filename
+		}
+		if rel, err := filepath.Rel(wd, abs); err == nil {
+			return rel
+		}
+	case "absolute":
+		if abs, err := filepath.Abs(filename); err == nil {
+			return abs
+		}
+	}
+	return filename
+}
+
+func allConfigs() [][]string {
+	out, err := exec.Command("go", "tool", "dist", "list", "-json").Output()
+	if err != nil {
+		fatal(err)
+	}
+
+	var platforms []struct {
+		GOOS, GOARCH string
 	}
 	err = json.Unmarshal(out, &platforms)
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
 	var res [][]string
@@ -290,10 +1601,20 @@ func allConfigs() [][]string {
 	return res
 }
 
-func mergeEdits(patterns []string, configs [][]string) fileToEditSet {
+// mergeEdits returns the edits that are valid in every configuration
+// in opts.Configs, along with the number of errors loading packages.
+func mergeEdits(ctx context.Context, opts *options, patterns []string) (fileToEditSet, int) {
 	m := make(fileToEditSet)
-	for _, config := range configs {
-		for f, e := range computeEdits(patterns, config) {
+	loadErrors := 0
+	for _, config := range opts.Configs {
+		if ctx.Err() != nil {
+			break
+		}
+		// If computeEdits times out, it returns the results
+		// for the files it got to, which are still correct.
+		edits, n := computeEdits(ctx, opts, patterns, config)
+		loadErrors += n
+		for f, e := range edits {
 			if e0, ok := m[f]; ok {
 				e0.intersect(e)
 			} else {
@@ -301,28 +1622,97 @@ func mergeEdits(patterns []string, configs [][]string) fileToEditSet {
 			}
 		}
 	}
-	return m
+	if opts.FirstOnly {
+		// Only now, so the one kept is unnecessary
+		// under every configuration.
+		for _, e := range m {
+			e.keepFirst()
+		}
+	}
+	return m, loadErrors
 }
 
-func computeEdits(patterns []string, config []string) fileToEditSet {
+func computeEdits(ctx context.Context, opts *options, patterns []string, config []string) (fileToEditSet, int) {
 	// TODO(mdempsky): Move into config?
 	var buildFlags []string
-	if *flagTags != "" {
-		buildFlags = []string{"-tags", *flagTags}
+	if opts.Tags != "" {
+		buildFlags = []string{"-tags", opts.Tags}
+	}
+	if opts.ModFile != "" {
+		buildFlags = append(buildFlags, "-modfile="+opts.ModFile)
 	}
 
-	pkgs, err := packages.Load(&packages.Config{
-		Mode:       packages.NeedSyntax | packages.NeedTypes | packages.NeedTypesInfo,
-		Env:        append(os.Environ(), config...),
+	env := append(os.Environ(), config...)
+	if opts.NoCgo {
+		env = append(env, "CGO_ENABLED=0")
+	}
+	if opts.GoList != "" {
+		exe, err := os.Executable()
+		if err != nil {
+			fatal(err)
+		}
+		env = append(env, "GOPACKAGESDRIVER="+exe, goListEnv+"="+opts.GoList)
+	}
+
+	cfg := &packages.Config{
+		Env:        env,
 		BuildFlags: buildFlags,
-		Tests:      *flagTests,
-	}, patterns...)
+		Tests:      opts.Tests,
+		Overlay:    opts.Overlay,
+		Context:    ctx,
+	}
+
+	m := make(fileToEditSet)
+
+	var cache *resultsCache
+	if opts.CacheDir != "" && opts.Overlay == nil {
+		// The cache keys are computed from the files on disk,
+		// so it can't be used with -stdin-file.
+		var err error
+		cache, err = openCache(opts, cfg, patterns)
+		if err != nil {
+			if ctx.Err() != nil {
+				return m, 0
+			}
+			fatal(err)
+		}
+		for f, e := range cache.hits {
+			m[f] = e
+		}
+		if len(cache.keys) == 0 {
+			// Everything was cached.
+			return m, 0
+		}
+		// With -reachable, the main packages are needed to
+		// know what to analyze, even if they're cached.
+		if !opts.Reachable {
+			patterns = cache.missPatterns(patterns)
+		}
+	}
+
+	cfg.Mode = packages.NeedSyntax | packages.NeedTypes | packages.NeedTypesInfo
+	if opts.Deps || opts.Reachable {
+		cfg.Mode |= packages.NeedName | packages.NeedImports | packages.NeedDeps | packages.NeedModule
+	}
+	roots, err := packages.Load(cfg, patterns...)
 	if err != nil {
-		log.Fatal(err)
+		if ctx.Err() != nil {
+			return m, 0
+		}
+		fatal(err)
+	}
+	if ctx.Err() != nil {
+		// Loading may have been cut short.
+		return m, 0
 	}
-	packages.PrintErrors(pkgs)
+	loadErrors := 0
+	if !opts.QuietLoad {
+		loadErrors = packages.PrintErrors(roots)
+	}
+	pkgs := analyzedPackages(opts, roots)
 
 	type res struct {
+		pkg   *packages.Package
 		file  string
 		edits editSet
 	}
@@ -330,6 +1720,12 @@ func computeEdits(patterns []string, config []string) fileToEditSet {
 	ch := make(chan res)
 	var wg sync.WaitGroup
 	for _, pkg := range pkgs {
+		if ctx.Err() != nil {
+			break
+		}
+		if cache != nil && !cache.missed(pkg) {
+			continue
+		}
 		for _, file := range pkg.Syntax {
 			pkg, file := pkg, file
 			tokenFile := pkg.Fset.File(file.Package)
@@ -339,13 +1735,46 @@ func computeEdits(patterns []string, config []string) fileToEditSet {
 			if strings.HasSuffix(filename, "-d") || strings.HasSuffix(filename, "/_cgo_gotypes.go") {
 				continue
 			}
+			if opts.NoCgo && importsC(file) {
+				continue
+			}
+			generated := isGenerated(file)
+			if generated && opts.Generated == "skip" {
+				continue
+			}
+			vendored := isVendored(filename)
+			if vendored && opts.Vendor == "skip" {
+				continue
+			}
+			if opts.Include != "" && !matchFile(opts.Include, filename) {
+				continue
+			}
+			if opts.Files != "" && !listsFile(opts.Files, filename) {
+				continue
+			}
+			if opts.OnlyFile != "" && !listsFile(opts.OnlyFile, filename) {
+				continue
+			}
+			if _, ok := opts.Overlay[filename]; opts.Overlay != nil && !ok {
+				continue
+			}
 
 			wg.Add(1)
 			go func() {
 				defer wg.Done()
-				v := visitor{info: pkg.TypesInfo, file: tokenFile, edits: make(editSet)}
+				v := visitor{
+					opts:      opts,
+					fset:      pkg.Fset,
+					pkg:       pkg.Types,
+					info:      pkg.TypesInfo,
+					file:      tokenFile,
+					src:       sourceFile{overlay: opts.Overlay},
+					edits:     make(editSet),
+					generated: generated,
+					vendored:  vendored,
+				}
 				ast.Walk(&v, file)
-				ch <- res{filename, v.edits}
+				ch <- res{pkg, filename, v.edits}
 			}()
 		}
 	}
@@ -354,11 +1783,186 @@ func computeEdits(patterns []string, config []string) fileToEditSet {
 		close(ch)
 	}()
 
-	m := make(fileToEditSet)
 	for r := range ch {
 		m[r.file] = r.edits
+		if cache != nil {
+			cache.record(r.pkg, r.file, r.edits)
+		}
+	}
+	if cache != nil && ctx.Err() == nil {
+		// After a timeout, some packages may not have
+		// been analyzed, so don't save anything.
+		cache.save(pkgs)
+	}
+	return m, loadErrors
+}
+
+// analyzedPackages returns the packages to analyze: the initial
+// packages and, with -deps, their dependencies from the main module
+// or matching -deps-prefix. With -reachable, only the main packages
+// among the initial packages and the packages they import are
+// analyzed.
+func analyzedPackages(opts *options, roots []*packages.Package) []*packages.Package {
+	pkgs := roots
+	if opts.Deps {
+		pkgs = withDeps(opts, roots)
+	}
+	if opts.Reachable {
+		pkgs = reachable(roots, pkgs)
+	}
+	return pkgs
+}
+
+// withDeps returns the initial packages and their dependencies from
+// the main module or matching -deps-prefix.
+func withDeps(opts *options, roots []*packages.Package) []*packages.Package {
+	var res []*packages.Package
+	packages.Visit(roots, nil, func(pkg *packages.Package) {
+		switch {
+		case opts.DepsPrefix != "":
+			if !strings.HasPrefix(pkg.PkgPath, opts.DepsPrefix) {
+				return
+			}
+		case pkg.Module == nil || !pkg.Module.Main:
+			return
+		}
+		res = append(res, pkg)
+	})
+
+	// Always analyze the initial packages, even if they
+	// don't match the filter.
+	seen := make(map[*packages.Package]bool)
+	for _, pkg := range res {
+		seen[pkg] = true
+	}
+	for _, pkg := range roots {
+		if !seen[pkg] {
+			res = append(res, pkg)
+		}
+	}
+	return res
+}
+
+// noMains warns once that -reachable found no main packages, though
+// packages may be loaded more than once.
+var noMains sync.Once
+
+// reachable returns the packages among pkgs that are main packages
+// among roots, or that they import directly or indirectly. This is a
+// package-level approximation of the code that's reachable from
+// them. Test main packages don't count, so code only used by tests
+// isn't reachable.
+func reachable(roots, pkgs []*packages.Package) []*packages.Package {
+	var mains []*packages.Package
+	for _, pkg := range roots {
+		// Test variants have IDs like "p [p.test]".
+		if pkg.Name == "main" && pkg.ID == pkg.PkgPath && !strings.HasSuffix(pkg.PkgPath, ".test") {
+			mains = append(mains, pkg)
+		}
+	}
+	if len(mains) == 0 {
+		noMains.Do(func() {
+			fmt.Fprintf(os.Stderr, "unconvert: -reachable: no main packages among the packages to analyze\n")
+		})
+	}
+
+	seen := make(map[*packages.Package]bool)
+	packages.Visit(mains, func(pkg *packages.Package) bool {
+		if seen[pkg] {
+			return false
+		}
+		seen[pkg] = true
+		return true
+	}, nil)
+
+	var res []*packages.Package
+	for _, pkg := range pkgs {
+		if seen[pkg] {
+			res = append(res, pkg)
+		}
+	}
+	return res
+}
+
+// importsC reports whether file imports the pseudo-package "C".
+func importsC(file *ast.File) bool {
+	for _, spec := range file.Imports {
+		if spec.Path.Value == `"C"` {
+			return true
+		}
+	}
+	return false
+}
+
+// generatedRx matches the comment that marks generated files.
+// See https://go.dev/s/generatedcode.
+var generatedRx = regexp.MustCompile(`^// Code generated .* DO NOT EDIT\.$`)
+
+// isGenerated reports whether file is a generated file, according
+// to the Go convention: a comment matching generatedRx before the
+// package clause.
+func isGenerated(file *ast.File) bool {
+	for _, group := range file.Comments {
+		if group.Pos() > file.Package {
+			break
+		}
+		for _, c := range group.List {
+			if generatedRx.MatchString(c.Text) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isVendored reports whether filename is in a vendor directory.
+func isVendored(filename string) bool {
+	return strings.Contains("/"+filepath.ToSlash(filename), "/vendor/")
+}
+
+// matchFile reports whether filename matches any of the
+// comma-separated glob patterns in list. Patterns are matched
+// against the file's path relative to the current directory,
+// its absolute path, and its base name.
+func matchFile(list string, filename string) bool {
+	names := []string{filename, filepath.Base(filename)}
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, filename); err == nil {
+			names = append(names, rel)
+		}
 	}
-	return m
+
+	for _, pattern := range strings.Split(list, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		for _, name := range names {
+			if ok, _ := filepath.Match(pattern, name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// listsFile reports whether filename names the same file as
+// one of the entries in the comma-separated list.
+func listsFile(list string, filename string) bool {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return false
+	}
+	for _, file := range strings.Split(list, ",") {
+		file = strings.TrimSpace(file)
+		if file == "" {
+			continue
+		}
+		if f, err := filepath.Abs(file); err == nil && f == abs {
+			return true
+		}
+	}
+	return false
 }
 
 type step struct {
@@ -367,10 +1971,53 @@ type step struct {
 }
 
 type visitor struct {
+	opts  *options
+	fset  *token.FileSet
+	pkg   *types.Package
 	info  *types.Info
 	file  *token.File
+	src   sourceFile
 	edits editSet
 	path  []step
+
+	// generated reports whether file is a generated file.
+	generated bool
+
+	// vendored reports whether file is in a vendor directory.
+	vendored bool
+}
+
+// missingType prints a note that an expression has no type, which
+// happens in packages with type errors, unless -quiet-load is set.
+func (v *visitor) missingType(a ...any) {
+	if !v.opts.QuietLoad {
+		fmt.Fprintln(os.Stderr, a...)
+	}
+}
+
+// source returns the source text of n. Expressions spanning
+// multiple lines are printed on one line instead.
+func (v *visitor) source(n ast.Expr) string {
+	// The offsets are relative to the file as parsed, which
+	// for files processed by cgo is cgo's output, so use the
+	// positions instead unless they're in the file itself.
+	start, end := v.file.Position(n.Pos()), v.file.Position(n.End())
+	if start.Filename == v.file.Name() && end.Filename == start.Filename {
+		buf := v.src.read(start.Filename)
+		if start.Offset <= end.Offset && end.Offset <= len(buf) {
+			if text := buf[start.Offset:end.Offset]; !bytes.Contains(text, nl) {
+				return string(text)
+			}
+		}
+		return types.ExprString(n)
+	}
+	if start.Filename == end.Filename && start.Line == end.Line {
+		line := v.src.line(start)
+		if start.Column <= end.Column && end.Column-1 <= len(line) {
+			return string(line[start.Column-1 : end.Column-1])
+		}
+	}
+	return types.ExprString(n)
 }
 
 func (v *visitor) Visit(node ast.Node) ast.Visitor {
@@ -397,9 +2044,19 @@ func (v *visitor) unconvert(call *ast.CallExpr) {
 	if len(call.Args) != 1 || call.Ellipsis != token.NoPos {
 		return
 	}
+	// Most calls are to functions named by an identifier or
+	// selector, which are quicker to rule out by the object
+	// it uses than by looking up the type of call.Fun.
+	if id := calleeIdent(call.Fun); id != nil {
+		if obj := v.info.Uses[id]; obj != nil {
+			if _, ok := obj.(*types.TypeName); !ok {
+				return
+			}
+		}
+	}
 	ft, ok := v.info.Types[call.Fun]
 	if !ok {
-		fmt.Println("Missing type for function")
+		v.missingType("Missing type for function")
 		return
 	}
 	if !ft.IsType() {
@@ -408,14 +2065,14 @@ func (v *visitor) unconvert(call *ast.CallExpr) {
 	}
 	at, ok := v.info.Types[call.Args[0]]
 	if !ok {
-		fmt.Println("Missing type for argument")
+		v.missingType("Missing type for argument")
 		return
 	}
 	if !types.Identical(ft.Type, at.Type) {
 		// A real conversion.
 		return
 	}
-	if !*flagFastMath && isFloatingPoint(ft.Type) {
+	if !v.opts.FastMath && isFloatingPoint(ft.Type) {
 		// As of Go 1.9, explicit floating-point type
 		// conversions are always significant because they
 		// force rounding and prevent operation fusing.
@@ -425,13 +2082,241 @@ func (v *visitor) unconvert(call *ast.CallExpr) {
 		// Workaround golang.org/issue/13061.
 		return
 	}
-	if *flagSafe && !v.isSafeContext(at.Type) {
+	if v.opts.ExportedOnly && !v.inExportedDecl() {
+		return
+	}
+	if keepsConversions(v.fset, v.opts.Overlay, ft.Type) {
+		// The type's declaration asks for conversions
+		// to it to be kept.
+		return
+	}
+	if v.opts.IgnorePkgs[typePkgPath(ft.Type)] {
+		return
+	}
+	if v.opts.StrictUntyped && at.Value != nil {
+		// Be conservative about constant expressions, in
+		// case isUntypedValue missed an untyped one.
+		return
+	}
+	if v.opts.KeepInlining && isFunc(ft.Type) && v.isCallee() {
+		// Converting a function value before calling it
+		// is sometimes used to keep the compiler from
+		// inlining or devirtualizing the call.
+		return
+	}
+	if v.opts.Safe && !v.isSafeContext(at.Type) {
 		// TODO(mdempsky): Remove this message.
-		fmt.Println("Skipped a possible type conversion because of -safe at", v.file.Position(call.Pos()))
+		fmt.Fprintln(os.Stderr, "Skipped a possible type conversion because of -safe at", v.file.Position(call.Pos()))
+		return
+	}
+
+	rule := v.rule(call.Fun, ft.Type, at)
+	if v.opts.Disable[rule] {
 		return
 	}
 
-	v.edits.add(v.file.Position(call.Lparen))
+	ed := edit{
+		Text:    v.source(call),
+		Type:    types.TypeString(ft.Type, types.RelativeTo(v.pkg)),
+		ArgType: types.TypeString(at.Type, types.RelativeTo(v.pkg)),
+		Func:    v.funcName(),
+		Rule:    rule,
+	}
+	if v.opts.Strict && hasSideEffects(call.Args[0], v.info) {
+		// The conversion may have been intended as an
+		// evaluation barrier; leave it for a human.
+		ed.ReportOnly = true
+	}
+	if v.opts.OnlySafe && (at.Value != nil || !isVariable(call.Args[0])) {
+		ed.ReportOnly = true
+	}
+	if v.generated && v.opts.Generated == "report" {
+		// Generated files should be fixed by fixing
+		// their generator instead.
+		ed.Generated = true
+		ed.ReportOnly = true
+	}
+	if v.vendored {
+		// Vendored code is overwritten by go mod vendor;
+		// fix it upstream instead.
+		ed.ReportOnly = true
+	}
+
+	v.edits.add(v.file.Position(call.Lparen), ed)
+}
+
+// calleeIdent returns the identifier that fun names, if it's an
+// identifier or selector, possibly parenthesized, or else nil.
+func calleeIdent(fun ast.Expr) *ast.Ident {
+	for {
+		paren, ok := fun.(*ast.ParenExpr)
+		if !ok {
+			break
+		}
+		fun = paren.X
+	}
+	switch fun := fun.(type) {
+	case *ast.Ident:
+		return fun
+	case *ast.SelectorExpr:
+		return fun.Sel
+	}
+	return nil
+}
+
+// typePkgPath returns the path of the package that declares t, or
+// that declares the base type of t if it's a pointer type. It returns
+// "" for predeclared and unnamed types.
+func typePkgPath(t types.Type) string {
+	for {
+		ptr, ok := t.(*types.Pointer)
+		if !ok {
+			break
+		}
+		t = ptr.Elem()
+	}
+	if named, ok := t.(*types.Named); ok && named.Obj().Pkg() != nil {
+		return named.Obj().Pkg().Path()
+	}
+	return ""
+}
+
+// Rule IDs categorize unnecessary conversions, so that -disable can
+// turn categories off.
+const (
+	ruleConst     = "unconvert/const"     // conversions of constant expressions
+	ruleAlias     = "unconvert/alias"     // conversions to types named by an alias, like byte(b)
+	ruleInterface = "unconvert/interface" // conversions to interface types
+	rulePointer   = "unconvert/pointer"   // conversions to pointer types, including unsafe.Pointer
+	ruleBasic     = "unconvert/basic"     // conversions to other basic types
+	ruleComposite = "unconvert/composite" // conversions to other types, like structs and slices
+)
+
+// rules lists all rule IDs.
+var rules = []string{ruleConst, ruleAlias, ruleInterface, rulePointer, ruleBasic, ruleComposite}
+
+// isRule reports whether id is a rule ID.
+func isRule(id string) bool {
+	for _, rule := range rules {
+		if rule == id {
+			return true
+		}
+	}
+	return false
+}
+
+// listSet returns the set of elements of the comma-separated list.
+func listSet(list string) map[string]bool {
+	var res map[string]bool
+	for _, s := range strings.Split(list, ",") {
+		if s == "" {
+			continue
+		}
+		if res == nil {
+			res = make(map[string]bool)
+		}
+		res[s] = true
+	}
+	return res
+}
+
+// rule returns the ID of the rule for a conversion to the type
+// t, spelled fun, of an operand with type and value at.
+func (v *visitor) rule(fun ast.Expr, t types.Type, at types.TypeAndValue) string {
+	if at.Value != nil {
+		return ruleConst
+	}
+
+	if obj, ok := v.info.Uses[calleeIdent(fun)].(*types.TypeName); ok && obj.IsAlias() {
+		return ruleAlias
+	}
+
+	switch u := t.Underlying().(type) {
+	case *types.Interface:
+		return ruleInterface
+	case *types.Pointer:
+		return rulePointer
+	case *types.Basic:
+		if u.Kind() == types.UnsafePointer {
+			return rulePointer
+		}
+		return ruleBasic
+	}
+	return ruleComposite
+}
+
+// keepDirective marks a type declaration whose conversions
+// should never be reported.
+const keepDirective = "//unconvert:keep"
+
+// keptTypes caches the result of keptTypesIn by file name.
+var keptTypes sync.Map // map[string]map[string]bool
+
+// keepsConversions reports whether t is a defined type whose
+// declaration is marked with keepDirective.
+func keepsConversions(fset *token.FileSet, overlay map[string][]byte, t types.Type) bool {
+	named, ok := t.(*types.Named)
+	if !ok {
+		return false
+	}
+	obj := named.Obj()
+	pos := fset.Position(obj.Pos())
+	if !pos.IsValid() {
+		return false
+	}
+	kept, ok := keptTypes.Load(pos.Filename)
+	if !ok {
+		kept, _ = keptTypes.LoadOrStore(pos.Filename, keptTypesIn(overlay, pos.Filename))
+	}
+	return kept.(map[string]bool)[fmt.Sprintf("%d %s", pos.Line, obj.Name())]
+}
+
+// keptTypesIn returns the types declared in file that are
+// marked with keepDirective, keyed by line number and name.
+// The file is parsed again, since it may belong to a package
+// loaded from export data.
+func keptTypesIn(overlay map[string][]byte, filename string) map[string]bool {
+	kept := make(map[string]bool)
+	src, err := readSource(overlay, filename)
+	if err != nil {
+		return kept
+	}
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, src, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return kept
+	}
+
+	ast.Inspect(file, func(n ast.Node) bool {
+		gd, ok := n.(*ast.GenDecl)
+		if !ok || gd.Tok != token.TYPE {
+			return true
+		}
+		for _, spec := range gd.Specs {
+			ts := spec.(*ast.TypeSpec)
+			// A directive on an ungrouped declaration
+			// is attached to the GenDecl instead.
+			if hasKeepDirective(ts.Doc) || gd.Lparen == token.NoPos && hasKeepDirective(gd.Doc) {
+				line := fset.Position(ts.Name.Pos()).Line
+				kept[fmt.Sprintf("%d %s", line, ts.Name.Name)] = true
+			}
+		}
+		return false
+	})
+	return kept
+}
+
+// hasKeepDirective reports whether doc contains keepDirective.
+func hasKeepDirective(doc *ast.CommentGroup) bool {
+	if doc == nil {
+		return false
+	}
+	for _, c := range doc.List {
+		if c.Text == keepDirective || strings.HasPrefix(c.Text, keepDirective+" ") {
+			return true
+		}
+	}
+	return false
 }
 
 // isFloatingPointer reports whether t's underlying type is a floating
@@ -441,6 +2326,146 @@ func isFloatingPoint(t types.Type) bool {
 	return ok && ut.Info()&(types.IsFloat|types.IsComplex) != 0
 }
 
+// hasSideEffects reports whether evaluating x may have side effects,
+// i.e., whether it contains a function call or channel receive.
+// Conversions and calls to side-effect-free builtins are okay.
+func hasSideEffects(x ast.Expr, info *types.Info) bool {
+	res := false
+	ast.Inspect(x, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			// The body isn't evaluated here.
+			return false
+		case *ast.CallExpr:
+			if tv, ok := info.Types[n.Fun]; ok && tv.IsType() {
+				break
+			}
+			if b, ok := asBuiltin(n.Fun, info); ok {
+				switch b.Name() {
+				case "len", "cap", "complex", "real", "imag", "min", "max":
+					return true
+				}
+			}
+			res = true
+		case *ast.UnaryExpr:
+			if n.Op == token.ARROW {
+				res = true
+			}
+		}
+		return !res
+	})
+	return res
+}
+
+// isVariable reports whether x is a (possibly parenthesized)
+// identifier or selector expression. Removing a conversion of such
+// an expression can't change evaluation order or overflow behavior.
+func isVariable(x ast.Expr) bool {
+	for {
+		switch n := x.(type) {
+		case *ast.ParenExpr:
+			x = n.X
+			continue
+		case *ast.Ident, *ast.SelectorExpr:
+			return true
+		}
+		return false
+	}
+}
+
+// isFunc reports whether t's underlying type is a function type.
+func isFunc(t types.Type) bool {
+	_, ok := t.Underlying().(*types.Signature)
+	return ok
+}
+
+// funcName returns the name of the function declaration enclosing
+// the current node, or "" if there is none. Methods are qualified
+// by their receiver's base type name, as in "T.M".
+func (v *visitor) funcName() string {
+	for i := len(v.path) - 1; i >= 0; i-- {
+		decl, ok := v.path[i].n.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+		if decl.Recv == nil || len(decl.Recv.List) == 0 {
+			return decl.Name.Name
+		}
+		recv := decl.Recv.List[0].Type
+		for {
+			switch x := recv.(type) {
+			case *ast.StarExpr:
+				recv = x.X
+				continue
+			case *ast.ParenExpr:
+				recv = x.X
+				continue
+			case *ast.IndexExpr:
+				recv = x.X
+				continue
+			case *ast.IndexListExpr:
+				recv = x.X
+				continue
+			}
+			break
+		}
+		return types.ExprString(recv) + "." + decl.Name.Name
+	}
+	return ""
+}
+
+// inExportedDecl reports whether the current node is within an
+// exported top-level declaration: an exported function, an exported
+// method of an exported type, or a type, variable, or constant
+// declaration that declares an exported name.
+func (v *visitor) inExportedDecl() bool {
+	// v.path[0] is the *ast.File.
+	if len(v.path) < 2 {
+		return false
+	}
+	switch decl := v.path[1].n.(type) {
+	case *ast.FuncDecl:
+		if !decl.Name.IsExported() {
+			return false
+		}
+		if decl.Recv == nil {
+			return true
+		}
+		name, _, _ := strings.Cut(v.funcName(), ".")
+		return ast.IsExported(name)
+	case *ast.GenDecl:
+		if len(v.path) < 3 {
+			return false
+		}
+		switch spec := v.path[2].n.(type) {
+		case *ast.TypeSpec:
+			return spec.Name.IsExported()
+		case *ast.ValueSpec:
+			for _, name := range spec.Names {
+				if name.IsExported() {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// isCallee reports whether the current node is the function operand
+// of a call expression, ignoring any enclosing parentheses.
+func (v *visitor) isCallee() bool {
+	for i := len(v.path) - 2; i >= 0; i-- {
+		switch v.path[i].n.(type) {
+		case *ast.ParenExpr:
+			continue
+		case *ast.CallExpr:
+			return v.path[i].i == 0
+		}
+		break
+	}
+	return false
+}
+
 // isSafeContext reports whether the current context requires
 // an expression of type t.
 //
@@ -451,7 +2476,7 @@ func (v *visitor) isSafeContext(t types.Type) bool {
 	case *ast.AssignStmt:
 		pos := ctxt.i - len(n.Lhs)
 		if pos < 0 {
-			fmt.Println("Type conversion on LHS of assignment?")
+			fmt.Fprintln(os.Stderr, "Type conversion on LHS of assignment?")
 			return false
 		}
 		if n.Tok == token.DEFINE {
@@ -462,7 +2487,7 @@ func (v *visitor) isSafeContext(t types.Type) bool {
 		// Check that the corresponding element of n.Lhs is of type t.
 		lt, ok := v.info.Types[n.Lhs[pos]]
 		if !ok {
-			fmt.Println("Missing type for LHS expression")
+			v.missingType("Missing type for LHS expression")
 			return false
 		}
 		return types.Identical(t, lt.Type)
@@ -473,7 +2498,7 @@ func (v *visitor) isSafeContext(t types.Type) bool {
 				return true
 			}
 			// For the LHS, we should inspect up another level.
-			fmt.Println("TODO(mdempsky): Handle LHS of shift expressions")
+			fmt.Fprintln(os.Stderr, "TODO(mdempsky): Handle LHS of shift expressions")
 			return true
 		}
 		var other ast.Expr
@@ -484,7 +2509,7 @@ func (v *visitor) isSafeContext(t types.Type) bool {
 		}
 		ot, ok := v.info.Types[other]
 		if !ok {
-			fmt.Println("Missing type for other binop subexpr")
+			v.missingType("Missing type for other binop subexpr")
 			return false
 		}
 		return types.Identical(t, ot.Type)
@@ -496,7 +2521,7 @@ func (v *visitor) isSafeContext(t types.Type) bool {
 		}
 		ft, ok := v.info.Types[n.Fun]
 		if !ok {
-			fmt.Println("Missing type for function expression")
+			v.missingType("Missing type for function expression")
 			return false
 		}
 		sig, ok := ft.Type.(*types.Signature)
@@ -513,7 +2538,7 @@ func (v *visitor) isSafeContext(t types.Type) bool {
 		}
 		return types.Identical(t, pt)
 	case *ast.CompositeLit, *ast.KeyValueExpr:
-		fmt.Println("TODO(mdempsky): Compare against value type of composite literal type at", v.file.Position(n.Pos()))
+		fmt.Fprintln(os.Stderr, "TODO(mdempsky): Compare against value type of composite literal type at", v.file.Position(n.Pos()))
 		return true
 	case *ast.ReturnStmt:
 		// TODO(mdempsky): Is there a better way to get the corresponding
@@ -545,11 +2570,11 @@ func (v *visitor) isSafeContext(t types.Type) bool {
 			break
 		}
 		if typeExpr == nil {
-			fmt.Println(ctxt)
+			fmt.Fprintln(os.Stderr, ctxt)
 		}
 		pt, ok := v.info.Types[typeExpr]
 		if !ok {
-			fmt.Println("Missing type for return parameter at", v.file.Position(n.Pos()))
+			v.missingType("Missing type for return parameter at", v.file.Position(n.Pos()))
 			return false
 		}
 		return types.Identical(t, pt.Type)
@@ -561,7 +2586,7 @@ func (v *visitor) isSafeContext(t types.Type) bool {
 		return true
 	default:
 		// TODO(mdempsky): When can this happen?
-		fmt.Printf("... huh, %T at %v\n", n, v.file.Position(n.Pos()))
+		fmt.Fprintf(os.Stderr, "... huh, %T at %v\n", n, v.file.Position(n.Pos()))
 		return true
 	}
 }
@@ -641,7 +2666,7 @@ func asBuiltin(n ast.Expr, info *types.Info) (*types.Builtin, bool) {
 	return b, ok
 }
 
-type byPosition []token.Position
+type byPosition []conversion
 
 func (p byPosition) Len() int {
 	return len(p)
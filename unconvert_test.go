@@ -5,78 +5,1842 @@
 package main_test
 
 import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	"go/build"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
 	"testing"
 )
 
+var exePath string
+
+func TestMain(m *testing.M) {
+	os.Exit(testMain(m))
+}
+
+func testMain(m *testing.M) int {
+	dir, err := os.MkdirTemp("", "unconvert")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	exePath = filepath.Join(dir, "test_unconvert.exe")
+	output, err := exec.Command("go", "build", "-o", exePath, ".").CombinedOutput()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to build service program: %v\n%v", err, string(output))
+		return 1
+	}
+
+	return m.Run()
+}
+
 func TestBinary(t *testing.T) {
-	exePath := build(t)
+	abs, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		dir  string
+		args []string
+	}{
+		{"relative", ".", []string{"./testdata"}},
+		{"dot", "./testdata", []string{"."}},
+		{"no-args", "./testdata", []string{}},
+		{"pattern", "./testdata", []string{"./..."}},
+		{"trailing-slash", ".", []string{"./testdata/"}},
+		{"parent", "./testdata/apply", []string{".."}},
+		{"absolute", ".", []string{abs}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := run(t, test.dir, test.args...)
+			check(t, got, expected(t, nil))
+		})
+	}
+}
+
+// TestDeterministic checks that output doesn't depend on the
+// order in which packages and files are analyzed concurrently.
+func TestDeterministic(t *testing.T) {
+	var first string
+	for _, procs := range []int{1, 2, 4, 8, 16} {
+		cmd := exec.Command(exePath, "-v", "./testdata")
+		cmd.Env = append(os.Environ(), fmt.Sprintf("GOMAXPROCS=%d", procs))
+		output, err := cmd.CombinedOutput()
+		if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+			t.Fatalf("GOMAXPROCS=%d: got %v, want exit status 1\n%s", procs, err, output)
+		}
+		if len(output) == 0 {
+			t.Fatalf("GOMAXPROCS=%d: no output", procs)
+		}
+		if first == "" {
+			first = string(output)
+		} else if string(output) != first {
+			t.Errorf("GOMAXPROCS=%d: output differs from GOMAXPROCS=1:\n%s\n---\n%s", procs, output, first)
+		}
+	}
+}
+
+func TestDirectories(t *testing.T) {
+	dir := tempModule(t, "example.com/dirs", map[string]string{
+		"internal/foo/foo.go": "package foo\n\nfunc _(x int) { _ = int(x) }\n",
+		"internal/bar/bar.go": "package bar\n\nfunc _(x int) { _ = int(x) }\n",
+	})
+
+	// Directories are resolved to packages, and reported
+	// relative to the module root.
+	for _, arg := range []string{"./internal/foo", "./internal/foo/", "./internal/../internal/foo"} {
+		cmd := exec.Command(exePath, "-paths=relative", arg)
+		cmd.Dir = dir
+		output, _ := cmd.CombinedOutput()
+		want := filepath.Join("internal", "foo", "foo.go") + ":3:24: unnecessary conversion: int(x)\n"
+		if string(output) != want {
+			t.Errorf("%s: got %q, want %q", arg, output, want)
+		}
+	}
+}
+
+func TestInclude(t *testing.T) {
+	got := run(t, ".", "-include=cgo.go,testdata/regress.go", "./testdata")
+	check(t, got, expected(t, func(ann Annotation) bool {
+		return ann.File == "cgo.go" || ann.File == "regress.go"
+	}))
+}
+
+func TestFiles(t *testing.T) {
+	got := run(t, "./testdata", "-files=cgo.go", ".")
+	check(t, got, expected(t, func(ann Annotation) bool {
+		return ann.File == "cgo.go"
+	}))
+}
+
+func TestNoCgo(t *testing.T) {
+	got := run(t, ".", "-no-cgo", "./testdata")
+	check(t, got, expected(t, func(ann Annotation) bool {
+		return ann.File != "cgo.go"
+	}))
+}
+
+func TestKeepInliningHints(t *testing.T) {
+	got := run(t, ".", "-keep-inlining-hints", "./testdata")
+	check(t, got, expected(t, func(ann Annotation) bool {
+		return ann.File != "inlining.go"
+	}))
+}
+
+func TestFormat(t *testing.T) {
+	got := run(t, ".", "-format={{.File}}:{{.Line}}:{{.Col}}: unnecessary conversion", "./testdata")
+	check(t, got, expected(t, nil))
+
+	cmd := exec.Command(exePath, "-format=<{{.Snippet}}>", "./testdata")
+	output, _ := cmd.CombinedOutput()
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if !strings.HasPrefix(line, "<") || !strings.HasSuffix(line, "//@ unnecessary conversion>") {
+			t.Errorf("unexpected snippet: %s", line)
+		}
+	}
+
+	// The conversion's text should appear in its source line.
+	cmd = exec.Command(exePath, "-format={{.Snippet}}\t{{.Text}}", "./testdata")
+	output, _ = cmd.CombinedOutput()
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		snippet, text, _ := strings.Cut(line, "\t")
+		if text == "" || !strings.Contains(snippet, text) {
+			t.Errorf("text %q not in snippet %q", text, snippet)
+		}
+	}
+}
+
+func TestShowTypes(t *testing.T) {
+	got := run(t, ".", "-show-types", "./testdata")
+	seen := make(map[string]bool)
+	for i, ann := range got {
+		msg, types, ok := strings.Cut(ann.Message, "; arg: ")
+		arg, target, _ := strings.Cut(strings.TrimSuffix(types, " (identical)"), ", target: ")
+		if !ok || arg == "" || target == "" {
+			t.Errorf("%s:%d: unexpected message: %s", ann.File, ann.Line, ann.Message)
+		}
+		seen[target] = true
+		seen[arg+" -> "+target] = true
+		got[i].Message = msg
+	}
+	check(t, got, expected(t, nil))
+
+	// The argument type is printed as it's spelled.
+	if !seen["interface{} -> any"] {
+		t.Errorf("missing finding converting interface{} to any")
+	}
+
+	// Types are qualified relative to the package being analyzed.
+	for _, typ := range []string{"bool", "ID", "*Metric", "io.Writer"} {
+		if !seen[typ] {
+			t.Errorf("missing finding with type %s", typ)
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module fingerprint\n\ngo 1.20\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	const src = `
+type T int
+
+func (t *T) M(x int) {
+	_ = int(x)
+	_ = int(x)
+	_ = T(*t)
+}
+
+func F(x int) {
+	_ = int(x)
+}
+`
+
+	fingerprints := func(prefix string) []string {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, "x.go"), []byte("package fingerprint\n"+prefix+src), 0666); err != nil {
+			t.Fatal(err)
+		}
+		cmd := exec.Command(exePath, "-format={{.Fingerprint}}", ".")
+		cmd.Dir = dir
+		output, _ := cmd.CombinedOutput()
+		prints := strings.Fields(string(output))
+		sort.Strings(prints)
+		return prints
+	}
+
+	before := fingerprints("")
+	if len(before) != 4 {
+		t.Fatalf("got %d fingerprints, want 4: %q", len(before), before)
+	}
+	for i := 1; i < len(before); i++ {
+		if before[i] == before[i-1] {
+			t.Errorf("duplicate fingerprint %s", before[i])
+		}
+	}
+
+	// Moving the findings to other lines doesn't change them.
+	after := fingerprints("\nfunc unrelated() {}\n\n")
+	if strings.Join(before, " ") != strings.Join(after, " ") {
+		t.Errorf("fingerprints changed:\nbefore: %q\nafter:  %q", before, after)
+	}
+}
+
+func TestColumns(t *testing.T) {
+	// Findings are reported at the left parenthesis of the
+	// conversion, rather than at the enclosing statement.
+	cmd := exec.Command(exePath, "-format={{.File}}\t{{.Line}}\t{{.Col}}\t{{.Text}}", "./testdata")
+	output, _ := cmd.CombinedOutput()
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.SplitN(line, "\t", 4)
+		if len(fields) != 4 {
+			t.Fatalf("unexpected output: %s", line)
+		}
+		if filepath.Base(fields[0]) == "cgo.go" {
+			// Columns are relative to cgo's output.
+			continue
+		}
+		ann := Annotation{File: filepath.Base(fields[0])}
+		ann.Line, _ = strconv.Atoi(fields[1])
+		col, _ := strconv.Atoi(fields[2])
+		text := fields[3]
+
+		src := sourceLine(t, ann)
+		found := false
+		for i := 0; i+len(text) <= len(src); i++ {
+			if src[i:i+len(text)] == text && i < col-1 && col-1 < i+len(text) {
+				found = true
+			}
+		}
+		if !found || col < 1 || col > len(src) || src[col-1] != '(' {
+			t.Errorf("%s:%d:%d: not at conversion %s in: %s", ann.File, ann.Line, col, text, src)
+		}
+	}
+}
+
+func TestExplain(t *testing.T) {
+	got := run(t, ".", "-explain", "./testdata")
+	reasons := make(map[string]bool)
+	for i, ann := range got {
+		msg, reason, ok := strings.Cut(ann.Message, "; ")
+		if !ok {
+			t.Errorf("%s:%d: unexpected message: %s", ann.File, ann.Line, ann.Message)
+		}
+		reasons[reason] = true
+		got[i].Message = msg
+	}
+	check(t, got, expected(t, nil))
+
+	for _, reason := range []string{
+		"argument is already int",
+		"argument type ID is identical to target",
+	} {
+		if !reasons[reason] {
+			t.Errorf("missing reason %q", reason)
+		}
+	}
+}
+
+func TestStaticcheckFormats(t *testing.T) {
+	t.Run("text", func(t *testing.T) {
+		want := expected(t, nil)
+		for i := range want {
+			want[i].Message += " (unconvert)"
+		}
+		check(t, run(t, ".", "-f=text", "./testdata"), want)
+	})
+
+	t.Run("stylish", func(t *testing.T) {
+		cmd := exec.Command(exePath, "-f=stylish", "./testdata")
+		output, _ := cmd.CombinedOutput()
+		t.Log(string(output))
+
+		want := expected(t, nil)
+		if n := strings.Count(string(output), "unconvert  unnecessary conversion\n"); n != len(want) {
+			t.Errorf("got %d findings, want %d", n, len(want))
+		}
+		if !strings.HasSuffix(string(output), fmt.Sprintf(" ✖ %d problems (%d errors, 0 warnings)\n", len(want), len(want))) {
+			t.Errorf("missing summary line")
+		}
+	})
+}
+
+func TestStrictUntyped(t *testing.T) {
+	got := run(t, ".", "-strict-untyped", "./testdata")
+	check(t, got, expected(t, func(ann Annotation) bool {
+		// All of enums.go's findings are constants, and
+		// cgo.go also converts the constant C.int(0).
+		return ann.File != "constants.go" && ann.File != "enums.go" &&
+			!strings.Contains(sourceLine(t, ann), "C.int(C.int(0))")
+	}))
+}
+
+func TestCheckstyle(t *testing.T) {
+	got := checkstyle(t, "warning")
+	check(t, got, expected(t, nil))
+
+	for _, severity := range []string{"info", "error"} {
+		checkstyle(t, severity, "-severity="+severity)
+	}
+}
+
+// checkstyle runs unconvert with -checkstyle and args on testdata,
+// checks that all errors have the given severity, and returns them.
+func checkstyle(t *testing.T, severity string, args ...string) []Annotation {
+	t.Helper()
+
+	cmd := exec.Command(exePath, append(append([]string{"-checkstyle"}, args...), "./testdata")...)
+	output, err := cmd.Output()
+	if err == nil {
+		t.Fatal("expected to quit with an error code")
+	}
+
+	var report struct {
+		Files []struct {
+			Name   string `xml:"name,attr"`
+			Errors []struct {
+				Line     int    `xml:"line,attr"`
+				Severity string `xml:"severity,attr"`
+				Message  string `xml:"message,attr"`
+				Source   string `xml:"source,attr"`
+			} `xml:"error"`
+		} `xml:"file"`
+	}
+	if err := xml.Unmarshal(output, &report); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []Annotation
+	for _, file := range report.Files {
+		for _, e := range file.Errors {
+			if e.Severity != severity || !strings.HasPrefix(e.Source, "unconvert/") {
+				t.Errorf("unexpected error attributes: %+v", e)
+			}
+			got = append(got, Annotation{filepath.Base(file.Name), e.Line, e.Message})
+		}
+	}
+	return got
+}
+
+func TestJSON(t *testing.T) {
+	cmd := exec.Command(exePath, "-json", "./testdata")
+	output, err := cmd.Output()
+	if err == nil {
+		t.Fatal("expected to quit with an error code")
+	}
+
+	var report struct {
+		Version  int
+		Findings []struct {
+			File, Text, Type, Rule, Severity, Fingerprint string
+			Line, Col                                     int
+		}
+	}
+	if err := json.Unmarshal(output, &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Version != 1 {
+		t.Errorf("got version %d, want 1", report.Version)
+	}
+
+	var got []Annotation
+	for _, f := range report.Findings {
+		if f.Text == "" || f.Type == "" || !strings.HasPrefix(f.Rule, "unconvert/") || f.Severity != "warning" || f.Fingerprint == "" || f.Col == 0 {
+			t.Errorf("unexpected finding: %+v", f)
+		}
+		got = append(got, Annotation{filepath.Base(f.File), f.Line, "unnecessary conversion"})
+	}
+	check(t, got, expected(t, nil))
+
+	// Clean packages have an empty list of findings.
+	dir := tempModule(t, "clean", map[string]string{"x.go": "package clean\n"})
+	cmd = exec.Command(exePath, "-json", ".")
+	cmd.Dir = dir
+	output, err = cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(strings.Fields(string(output)), " "), `{ "version": 1, "findings": [] }`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	// Notes about type errors don't get mixed into the report.
+	dir = tempModule(t, "broken", map[string]string{
+		"x.go": "package broken\n\nfunc _(x int) {\n\t_ = int(x)\n\t_ = undefined(x)\n\t_ = int(undefined)\n}\n",
+	})
+	cmd = exec.Command(exePath, "-json", ".")
+	cmd.Dir = dir
+	output, _ = cmd.Output()
+	report.Findings = nil
+	if err := json.Unmarshal(output, &report); err != nil {
+		t.Fatalf("%v\n%s", err, output)
+	}
+	if len(report.Findings) != 1 || report.Findings[0].Line != 4 {
+		t.Errorf("got %+v, want one finding on line 4", report.Findings)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	dir := tempModule(t, "merge", map[string]string{
+		"a/x.go": "package a\n\nfunc _(x int) { _ = int(x) }\n",
+		"b/y.go": "package b\n\nfunc _(y int) {\n\t_ = int(y)\n\t_ = int(y)\n}\n",
+	})
+
+	// Shards that overlap in b.
+	for _, shard := range [][]string{{"./a", "./b"}, {"./b"}} {
+		cmd := exec.Command(exePath, append([]string{"-json"}, shard...)...)
+		cmd.Dir = dir
+		output, _ := cmd.Output()
+		file := filepath.Join(dir, fmt.Sprintf("shard%d.json", len(shard)))
+		if err := os.WriteFile(file, output, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command(exePath, "-merge", "-format={{.File}}:{{.Line}}", "shard2.json", "shard1.json")
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+		t.Errorf("got %v, want exit status 1\n%s", err, output)
+	}
+	want := fmt.Sprintf("%s:3\n%s:4\n%s:5\n", filepath.Join("a", "x.go"), filepath.Join("b", "y.go"), filepath.Join("b", "y.go"))
+	if got := strings.ReplaceAll(string(output), dir+string(filepath.Separator), ""); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Reports in another format version can't be merged.
+	if err := os.WriteFile(filepath.Join(dir, "v2.json"), []byte(`{"version": 2, "findings": []}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	cmd = exec.Command(exePath, "-merge", "shard1.json", "v2.json")
+	cmd.Dir = dir
+	output, err = cmd.CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 3 {
+		t.Errorf("got %v, want exit status 3\n%s", err, output)
+	}
+}
+
+func TestFirstOnly(t *testing.T) {
+	cmd := exec.Command(exePath, "-first-only", "./testdata")
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatal("expected to quit with an error code")
+	}
+	got, err := ParseOutput(t, "testdata", string(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the first finding in each file is reported.
+	all := expected(t, nil)
+	SortAnnotations(all)
+	var want []Annotation
+	for _, ann := range all {
+		if n := len(want); n == 0 || want[n-1].File != ann.File {
+			want = append(want, ann)
+		}
+	}
+	check(t, got, want)
+}
+
+func TestFirstOnlyAll(t *testing.T) {
+	dir := tempModule(t, "first", map[string]string{
+		"t_linux.go": "package first\n\ntype T = int64\n",
+		"t_other.go": "//go:build !linux\n\npackage first\n\ntype T = int32\n",
+		"x.go":       "package first\n\nfunc _(t T) int64 { return int64(t) }\n\nfunc _(x int) int { return int(x) }\n",
+	})
+
+	// The first conversion is only unnecessary on linux, so the
+	// one reported is the first that is on every platform.
+	cmd := exec.Command(exePath, "-all", "-first-only", "-paths=relative", ".")
+	cmd.Dir = dir
+	output, _ := cmd.CombinedOutput()
+	if got, want := string(output), "x.go:5:31: unnecessary conversion: int(x)\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// BenchmarkUnconvert measures a run over a large package. Type
+// checking dominates, so this mostly guards against slower loading
+// and more garbage collection, not just a slower walk.
+func TestOutputFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "report.json")
+	cmd := exec.Command(exePath, "-json", "-o", file, "./testdata")
+	output, err := cmd.Output()
+	if err == nil {
+		t.Fatal("expected to quit with an error code")
+	}
+
+	// People get the default format on standard output...
+	got, err := ParseOutput(t, "testdata", string(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := expected(t, nil)
+	check(t, got, want)
+
+	// ... and machines get the JSON report in the file.
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report struct {
+		Version  int
+		Findings []struct{ File string }
+	}
+	if err := json.Unmarshal(buf, &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Version != 1 || len(report.Findings) != len(want) {
+		t.Errorf("got version %d with %d findings, want version 1 with %d", report.Version, len(report.Findings), len(want))
+	}
+}
+
+func TestConfigPrint(t *testing.T) {
+	cmd := exec.Command(exePath, "-config-print", "-tags=foo bar", "-vendor=report", "-disable=unconvert/const", "-format={{.File}}", "-w", "-timeout=1m", "-o=report.txt", "-q", "./testdata")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, output)
+	}
+
+	var config struct {
+		Patterns []string
+		Tags     string
+		Vendor   string
+		Disable  map[string]bool
+		Format   string
+		Tests    bool
+		Apply    bool
+		Timeout  string
+		Out      string
+		Quiet    bool
+	}
+	if err := json.Unmarshal(output, &config); err != nil {
+		t.Fatalf("%v\n%s", err, output)
+	}
+	if got, want := fmt.Sprintf("%+v", config), "{Patterns:[./testdata] Tags:foo bar Vendor:report Disable:map[unconvert/const:true] Format:{{.File}}\n Tests:true Apply:true Timeout:1m0s Out:report.txt Quiet:true}"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestCount(t *testing.T) {
+	cmd := exec.Command(exePath, "-count", "./testdata")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, output)
+	}
+
+	want := len(expected(t, nil))
+	if got, err := strconv.Atoi(strings.TrimSpace(string(output))); err != nil || got != want {
+		t.Errorf("got %q, want %d", output, want)
+	}
+}
+
+func TestStats(t *testing.T) {
+	cmd := exec.Command(exePath, "-stats", "./testdata")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, output)
+	}
+
+	var stats struct {
+		Files             int
+		FilesWithFindings int `json:"files_with_findings"`
+		Findings          int
+		Rules             map[string]int
+		Elapsed           float64 `json:"elapsed_seconds"`
+	}
+	if err := json.Unmarshal(output, &stats); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := len(expected(t, nil)); stats.Findings != want {
+		t.Errorf("got %d findings, want %d", stats.Findings, want)
+	}
+	if stats.FilesWithFindings == 0 || stats.FilesWithFindings > stats.Files {
+		t.Errorf("got %d files with findings of %d files", stats.FilesWithFindings, stats.Files)
+	}
+	sum := 0
+	for _, n := range stats.Rules {
+		sum += n
+	}
+	if sum != stats.Findings || len(stats.Rules) != 6 {
+		t.Errorf("got rules %v, want 6 rules adding up to %d", stats.Rules, stats.Findings)
+	}
+	if stats.Elapsed <= 0 {
+		t.Errorf("got elapsed time %v, want > 0", stats.Elapsed)
+	}
+}
+
+func TestExportedOnly(t *testing.T) {
+	dir := tempModule(t, "exported", map[string]string{
+		"x.go": `package exported
+
+type T int
+type u int
+
+func F(x int) { _ = int(x) }          // reported
+func f(x int) { _ = int(x) }          // not reported
+func (t T) M() { _ = T(t) }           // reported
+func (t T) m() { _ = T(t) }           // not reported
+func (v u) M() { _ = u(v) }           // not reported
+
+var V = int(len(""))                  // reported
+var w = int(len(""))                  // not reported
+
+type A [int(len("ab"))]int            // reported
+type b [int(len("ab"))]int            // not reported
+`,
+	})
+
+	cmd := exec.Command(exePath, "-exported-only", "-format={{.Line}}", ".")
+	cmd.Dir = dir
+	output, _ := cmd.CombinedOutput()
+	if got, want := strings.Fields(string(output)), []string{"6", "8", "12", "15"}; strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("got findings on lines %v, want %v\n%s", got, want, output)
+	}
+}
+
+func TestDisable(t *testing.T) {
+	dir := tempModule(t, "disable", map[string]string{
+		"x.go": `package disable
+
+import "unsafe"
+
+type S struct{ X int }
+
+func _(b byte, e error, p *int, q unsafe.Pointer, i int, s S) {
+	_ = byte(b)            // unconvert/alias
+	_ = error(e)           // unconvert/interface
+	_ = (*int)(p)          // unconvert/pointer
+	_ = unsafe.Pointer(q)  // unconvert/pointer
+	_ = int(i)             // unconvert/basic
+	_ = S(s)               // unconvert/composite
+	_ = int(len("abc"))    // unconvert/const
+}
+`,
+	})
+
+	for _, test := range []struct {
+		disable string
+		want    []string
+	}{
+		{"", []string{"alias", "interface", "pointer", "pointer", "basic", "composite", "const"}},
+		{"unconvert/alias,unconvert/const", []string{"interface", "pointer", "pointer", "basic", "composite"}},
+		{"unconvert/pointer", []string{"alias", "interface", "basic", "composite", "const"}},
+	} {
+		cmd := exec.Command(exePath, "-disable="+test.disable, "-format={{.Rule}}", ".")
+		cmd.Dir = dir
+		output, _ := cmd.CombinedOutput()
+		var want []string
+		for _, rule := range test.want {
+			want = append(want, "unconvert/"+rule)
+		}
+		if got := strings.Fields(string(output)); strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("-disable=%s: got rules %v, want %v\n%s", test.disable, got, want, output)
+		}
+	}
+
+	// Unknown rules are usage errors.
+	cmd := exec.Command(exePath, "-disable=unconvert/bogus", ".")
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 2 {
+		t.Errorf("got %v, want exit status 2\n%s", err, output)
+	}
+}
+
+func TestFrom(t *testing.T) {
+	dir := tempModule(t, "from", map[string]string{
+		"a/x.go": "package a\n\nfunc _(x int) { _ = int(x) }\n",
+		"b/x.go": "package b\n\nfunc _(x int) { _ = int(x) }\n",
+		"c/x.go": "package c\n\nfunc _(x int) { _ = int(x) }\n",
+		"d/x.go": "package d\n\nfunc _(x int) { _ = int(x) }\n",
+
+		"one.txt": "./a\n./b\n",
+		"two.txt": "# Generated list.\n./b\n\n./c\n",
+	})
+
+	cmd := exec.Command(exePath, "-from=one.txt", "-from=two.txt", "-paths=relative", "-format={{.File}}", "./d")
+	cmd.Dir = dir
+	output, _ := cmd.CombinedOutput()
+	var want []string
+	for _, pkg := range []string{"a", "b", "c", "d"} {
+		want = append(want, filepath.Join(pkg, "x.go"))
+	}
+	if got := strings.Fields(string(output)); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestGoList(t *testing.T) {
+	dir := tempModule(t, "golist", map[string]string{
+		"a/a.go": "package a\n\nimport \"golist/b\"\n\nfunc F(n int) int { return int(b.G(n)) }\n",
+		"b/b.go": "package b\n\nfunc G(n int) int { return int(n) }\n",
+	})
+
+	cmd := exec.Command("go", "list", "-deps", "-json", "./a")
+	cmd.Dir = dir
+	list, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "golist.json")
+	if err := os.WriteFile(file, list, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only a is analyzed, since b is only a dependency, and the
+	// go command isn't needed.
+	cmd = exec.Command(exePath, "-golist="+file, "-format={{.File}}:{{.Line}}")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "PATH=")
+	output, err := cmd.CombinedOutput()
+	if err, ok := err.(*exec.ExitError); !ok || err.ExitCode() != 1 {
+		t.Fatalf("got %v, want exit status 1\n%s", err, output)
+	}
+	if got, want := string(output), filepath.Join(dir, "a", "a.go")+":5\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	cmd = exec.Command(exePath, "-golist="+file, "./a")
+	cmd.Dir = dir
+	output, err = cmd.CombinedOutput()
+	if err, ok := err.(*exec.ExitError); !ok || err.ExitCode() != 2 {
+		t.Errorf("with patterns: got %v, want exit status 2\n%s", err, output)
+	}
+
+	// The variable unconvert passes to itself as the driver
+	// doesn't make an ordinary run act as one.
+	cmd = exec.Command(exePath, "-format={{.File}}:{{.Line}}", "./b")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "UNCONVERT_GOLIST="+file)
+	output, err = cmd.CombinedOutput()
+	if err, ok := err.(*exec.ExitError); !ok || err.ExitCode() != 1 {
+		t.Errorf("with UNCONVERT_GOLIST: got %v, want exit status 1\n%s", err, output)
+	}
+	if got, want := string(output), filepath.Join(dir, "b", "b.go")+":3\n"; got != want {
+		t.Errorf("with UNCONVERT_GOLIST: got %q, want %q", got, want)
+	}
+}
+
+func TestIgnorePkg(t *testing.T) {
+	dir := tempModule(t, "ignore", map[string]string{
+		"units/units.go": "package units\n\ntype Meters float64\n",
+		"x.go": `package ignore
+
+import (
+	"time"
+
+	"ignore/units"
+)
+
+func _(d time.Duration, p *time.Duration, m units.Meters, n int) {
+	_ = time.Duration(d)
+	_ = (*time.Duration)(p)
+	_ = units.Meters(m)
+	_ = int(n)
+}
+`,
+	})
+
+	for _, test := range []struct {
+		ignore string
+		want   []string
+	}{
+		{"", []string{"time.Duration(d)", "(*time.Duration)(p)", "units.Meters(m)", "int(n)"}},
+		{"time", []string{"units.Meters(m)", "int(n)"}},
+		{"time,ignore/units", []string{"int(n)"}},
+		{"ignore", []string{"time.Duration(d)", "(*time.Duration)(p)", "units.Meters(m)", "int(n)"}},
+	} {
+		cmd := exec.Command(exePath, "-ignore-pkg="+test.ignore, "-fastmath", "-format={{.Text}}", ".")
+		cmd.Dir = dir
+		output, _ := cmd.CombinedOutput()
+		if got := strings.Fields(string(output)); strings.Join(got, " ") != strings.Join(test.want, " ") {
+			t.Errorf("-ignore-pkg=%s: got %v, want %v\n%s", test.ignore, got, test.want, output)
+		}
+	}
+}
+
+func TestRoot(t *testing.T) {
+	dir := tempModule(t, "root", map[string]string{
+		"sub/x.go": "package sub\n\nfunc _(x int) { _ = int(x) }\n",
+	})
+
+	// Package patterns and relative paths are resolved
+	// against -root, not the working directory.
+	cmd := exec.Command(exePath, "-root="+dir, "-paths=relative", "./...")
+	cmd.Dir = t.TempDir()
+	output, _ := cmd.CombinedOutput()
+	if got, want := string(output), filepath.Join("sub", "x.go")+":3:24: unnecessary conversion: int(x)\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	dir := tempModule(t, "status", map[string]string{
+		"clean/x.go":    "package clean\n",
+		"findings/x.go": "package findings\n\nfunc _(x int) { _ = int(x) }\n",
+		"broken/x.go":   "package broken\n\nfunc _(x int) { _ = int(y) }\n",
+	})
+
+	tests := []struct {
+		args []string
+		want int
+	}{
+		{[]string{"./clean"}, 0},
+		{[]string{"./findings"}, 1},
+		{[]string{"-paths=bogus", "./clean"}, 2},
+		{[]string{"./broken"}, 3},
+		{[]string{"./findings", "./broken"}, 3},
+		{[]string{"-quiet-load", "./broken"}, 0},
+		{[]string{"-quiet-load", "./findings", "./broken"}, 1},
+	}
+	for _, test := range tests {
+		cmd := exec.Command(exePath, test.args...)
+		cmd.Dir = dir
+		output, err := cmd.CombinedOutput()
+		got := 0
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			got = exitErr.ExitCode()
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("%v: got exit status %d, want %d\n%s", test.args, got, test.want, output)
+		}
+	}
+}
+
+func TestQuietLoad(t *testing.T) {
+	dir := tempModule(t, "quiet", map[string]string{
+		"findings/x.go": "package findings\n\nfunc _(x int) { _ = int(x) }\n",
+		"broken/x.go":   "package broken\n\nfunc _(x int) { _ = int(y) }\n",
+	})
+
+	cmd := exec.Command(exePath, "-quiet-load", "-format={{.Text}}", "./...")
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, _ := cmd.Output()
+	if got, want := string(output), "int(x)\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if stderr.Len() != 0 {
+		t.Errorf("got errors:\n%s", stderr.Bytes())
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	cmd := exec.Command(exePath, "-timeout=1ns", "./testdata")
+	output, err := cmd.CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 3 {
+		t.Errorf("got error %v, want exit status 3", err)
+	}
+	if !strings.Contains(string(output), "timed out after 1ns") {
+		t.Errorf("missing timeout warning:\n%s", output)
+	}
+
+	// A generous timeout doesn't change anything.
+	check(t, run(t, ".", "-timeout=10m", "./testdata"), expected(t, nil))
+}
+
+func TestGenerated(t *testing.T) {
+	const gen = "// Code generated by hand. DO NOT EDIT.\n\npackage gen\n\nfunc _(x int) { _ = int(x) }\n"
+	const src = "package gen\n\nfunc _(y int) { _ = int(y) }\n"
+
+	tests := []struct {
+		mode string
+		want []string // findings, in -format={{.File}}:{{.Generated}} form
+	}{
+		{"include", []string{"gen.go:false", "x.go:false"}},
+		{"skip", []string{"x.go:false"}},
+		{"report", []string{"gen.go:true", "x.go:false"}},
+	}
+	for _, test := range tests {
+		t.Run(test.mode, func(t *testing.T) {
+			dir := tempModule(t, "gen", map[string]string{"gen.go": gen, "x.go": src})
+			cmd := exec.Command(exePath, "-generated="+test.mode, "-paths=relative", "-format={{.File}}:{{.Generated}}", ".")
+			cmd.Dir = dir
+			output, _ := cmd.CombinedOutput()
+			if got := strings.Fields(string(output)); strings.Join(got, " ") != strings.Join(test.want, " ") {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+
+	// With -apply, findings in generated files are only reported.
+	dir := tempModule(t, "gen", map[string]string{"gen.go": gen, "x.go": src})
+	cmd := exec.Command(exePath, "-generated=report", "-apply", "-paths=relative", ".")
+	cmd.Dir = dir
+	output, _ := cmd.CombinedOutput()
+	if got, want := string(output), "gen.go:5:24: unnecessary conversion (generated): int(x)\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if buf, _ := os.ReadFile(filepath.Join(dir, "gen.go")); string(buf) != gen {
+		t.Errorf("gen.go was modified:\n%s", buf)
+	}
+	if buf, _ := os.ReadFile(filepath.Join(dir, "x.go")); strings.Contains(string(buf), "int(y)") {
+		t.Errorf("x.go wasn't modified:\n%s", buf)
+	}
+}
+
+func TestVendor(t *testing.T) {
+	const dep = "package dep\n\nfunc _(x int) { _ = int(x) }\n"
+	files := map[string]string{
+		"lib/vendor/dep/dep.go": dep,
+		"x.go":                  "package vendored\n\nfunc _(y int) { _ = int(y) }\n",
+	}
+
+	tests := []struct {
+		mode string
+		want []string
+	}{
+		{"skip", []string{"x.go"}},
+		{"report", []string{"lib/vendor/dep/dep.go", "x.go"}},
+	}
+	for _, test := range tests {
+		t.Run(test.mode, func(t *testing.T) {
+			dir := tempModule(t, "vendored", files)
+			cmd := exec.Command(exePath, "-vendor="+test.mode, "-paths=relative", "-format={{.File}}", ".", "./lib/vendor/dep")
+			cmd.Dir = dir
+			output, _ := cmd.CombinedOutput()
+			var want []string
+			for _, file := range test.want {
+				want = append(want, filepath.FromSlash(file))
+			}
+			if got := strings.Fields(string(output)); strings.Join(got, " ") != strings.Join(want, " ") {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+
+	// With -apply, findings in vendored files are only reported.
+	dir := tempModule(t, "vendored", files)
+	cmd := exec.Command(exePath, "-vendor=report", "-apply", ".", "./lib/vendor/dep")
+	cmd.Dir = dir
+	cmd.Run()
+	if buf, _ := os.ReadFile(filepath.Join(dir, "lib", "vendor", "dep", "dep.go")); string(buf) != dep {
+		t.Errorf("dep.go was modified:\n%s", buf)
+	}
+}
+
+func TestQuiet(t *testing.T) {
+	clean := t.TempDir()
+	if err := os.WriteFile(filepath.Join(clean, "go.mod"), []byte("module quiet\n\ngo 1.20\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(clean, "x.go"), []byte("package quiet\n\nfunc _(x int32) { _ = int64(x) }\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, flag := range []string{"-q", "-quiet"} {
+		cmd := exec.Command(exePath, flag, "./testdata")
+		output, err := cmd.CombinedOutput()
+		if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+			t.Errorf("%s: got error %v, want exit status 1", flag, err)
+		}
+		if len(output) != 0 {
+			t.Errorf("%s: unexpected output:\n%s", flag, output)
+		}
+
+		cmd = exec.Command(exePath, flag, ".")
+		cmd.Dir = clean
+		output, err = cmd.CombinedOutput()
+		if err != nil || len(output) != 0 {
+			t.Errorf("%s: clean package: %v\n%s", flag, err, output)
+		}
+	}
+}
+
+func TestStdinFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module stdin\n\ngo 1.20\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a.go"), []byte("package stdin\n\ntype T int\n\nfunc _(t T) { _ = T(t) }\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// b.go doesn't exist on disk, and findings in a.go
+	// aren't reported.
+	cmd := exec.Command(exePath, "-v", "-stdin-file=b.go")
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader("package stdin\n\nfunc _(t T) T {\n\treturn T(t)\n}\n")
+	output, _ := cmd.CombinedOutput()
+	want := filepath.Join(dir, "b.go") + ":4:10: unnecessary conversion: T(t)\n\treturn T(t)\n\t        ^\n"
+	if string(output) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", output, want)
+	}
+}
+
+func TestModFile(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		// The go command still needs a go.mod to find the
+		// module root, but doesn't read it with -modfile.
+		"go.mod":    "not a valid go.mod\n",
+		"tools.mod": "module tools\n\ngo 1.20\n",
+		"x.go":      "package tools\n\nfunc _(x int) { _ = int(x) }\n",
+	}
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command(exePath, "-count", "-modfile=tools.mod", ".")
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, output)
+	}
+	if got := strings.TrimSpace(string(output)); got != "1" {
+		t.Errorf("got %s findings, want 1", got)
+	}
+}
+
+func TestCrossTarget(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping cross-target build in short mode")
+	}
+
+	ctxt := build.Default
+	ctxt.GOOS, ctxt.GOARCH = "windows", "386"
+	if runtime.GOOS == "windows" {
+		ctxt.GOOS = "linux"
+	}
+	ctxt.CgoEnabled = false // cgo is off when cross compiling
+
+	all, err := parseDir(&ctxt, "testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []Annotation
+	for _, ann := range all {
+		// MatchFile doesn't check for import "C".
+		if ann.File != "cgo.go" {
+			want = append(want, ann)
+		}
+	}
+
+	cmd := exec.Command(exePath, "./testdata")
+	cmd.Env = append(os.Environ(), "GOOS="+ctxt.GOOS, "GOARCH="+ctxt.GOARCH)
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatal("expected to quit with an error code")
+	}
+	got, err := ParseOutput(t, "testdata", string(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, got, want)
+}
+
+// TestTagsCrossTarget checks that custom build tags are combined with
+// GOOS the way the go command combines them.
+func TestTagsCrossTarget(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping cross-target build in short mode")
+	}
+
+	for _, goos := range []string{"windows", "linux"} {
+		ctxt := build.Default
+		ctxt.GOOS, ctxt.GOARCH = goos, "amd64"
+		ctxt.BuildTags = []string{"unconvert_other"}
+		ctxt.CgoEnabled = false // cgo is off when cross compiling
+
+		all, err := parseDir(&ctxt, "testdata")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want []Annotation
+		files := make(map[string]bool)
+		for _, ann := range all {
+			// MatchFile doesn't check for import "C".
+			if ann.File != "cgo.go" {
+				want = append(want, ann)
+				files[ann.File] = true
+			}
+		}
+		// build_other.go is built with the tag on any GOOS,
+		// and build_linux.go only on linux.
+		if !files["build_other.go"] || files["build_linux.go"] != (goos == "linux") {
+			t.Fatalf("GOOS=%s: unexpected files %v", goos, files)
+		}
+
+		cmd := exec.Command(exePath, "-tags=unconvert_other", "./testdata")
+		cmd.Env = append(os.Environ(), "GOOS="+goos, "GOARCH=amd64", "CGO_ENABLED=0")
+		output, err := cmd.CombinedOutput()
+		if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+			t.Fatalf("GOOS=%s: got %v, want exit status 1\n%s", goos, err, output)
+		}
+		got, err := ParseOutput(t, "testdata", string(output))
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, got, want)
+	}
+}
+
+func TestApplyCgo(t *testing.T) {
+	if !build.Default.CgoEnabled {
+		t.Skip("cgo is disabled")
+	}
+	if _, err := exec.LookPath("gcc"); err != nil {
+		if _, err := exec.LookPath("clang"); err != nil {
+			t.Skip("no C compiler")
+		}
+	}
+
+	const src = `package cgo
+
+// void foo(int x) {}
+// void bar(int* x) {}
+import "C"
+
+func _(q *C.int) {
+	C.foo(C.int(C.int(0)))
+	C.bar((*C.int)((*C.int)(nil)))
+	C.bar((*C.int)(q))
+}
+`
+	const want = `package cgo
+
+// void foo(int x) {}
+// void bar(int* x) {}
+import "C"
+
+func _(q *C.int) {
+	C.foo(C.int(0))
+	C.bar((*C.int)(nil))
+	C.bar(q)
+}
+`
+	dir := tempModule(t, "cgo", map[string]string{"x.go": src})
+	cmd := exec.Command(exePath, "-apply", ".")
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v\n%s", err, output)
+	}
+	buf, err := os.ReadFile(filepath.Join(dir, "x.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf, want)
+	}
+
+	// The result must still build.
+	cmd = exec.Command("go", "build", "./...")
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("go build: %v\n%s", err, output)
+	}
+}
+
+func TestApplyTrailingComment(t *testing.T) {
+	const src = "package c\n\nfunc _(a, b int) {\n\t_ = int(a /* c */)\n\t_ = int(b)\n}\n"
+	const want = "package c\n\nfunc _(a, b int) {\n\t_ = int(a /* c */)\n\t_ = b\n}\n"
+	dir := tempModule(t, "c", map[string]string{"x.go": src})
+
+	// The conversion with a comment before its closing
+	// parenthesis is kept, so it's reported instead.
+	cmd := exec.Command(exePath, "-apply", "-format={{.Line}}", ".")
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+		t.Errorf("got %v, want exit status 1\n%s", err, output)
+	}
+	if got := string(output); got != "4\n" {
+		t.Errorf("got output %q, want %q", got, "4\n")
+	}
+	if buf, _ := os.ReadFile(filepath.Join(dir, "x.go")); string(buf) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf, want)
+	}
+}
+
+func TestApplyAliases(t *testing.T) {
+	for _, flag := range []string{"-apply", "-fix", "-w"} {
+		dir := tempModule(t, "alias", map[string]string{
+			"x.go": "package alias\n\nfunc _(x int) { _ = int(x) }\n",
+		})
+		cmd := exec.Command(exePath, flag, ".")
+		cmd.Dir = dir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Errorf("%s: %v\n%s", flag, err, output)
+		}
+		buf, err := os.ReadFile(filepath.Join(dir, "x.go"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "package alias\n\nfunc _(x int) { _ = x }\n"; string(buf) != want {
+			t.Errorf("%s: got:\n%s\nwant:\n%s", flag, buf, want)
+		}
+	}
+}
+
+func TestPatchDir(t *testing.T) {
+	const src = "package a\n\nfunc _(x int) {\n\t_ = int(x)\n}\n"
+	files := map[string]string{
+		"a/x.go": src,
+		"b/y.go": "package b\n\nfunc _(x, y int) int {\n\t_ = int(x)\n\t_ = y\n\t_ = y\n\t_ = y\n\t_ = y\n\t_ = y\n\t_ = y\n\t_ = y\n\treturn int(y)\n}\n",
+		"c/z.go": "package c\n",
+	}
+	dir := tempModule(t, "patch", files)
+	out := t.TempDir()
+
+	cmd := exec.Command(exePath, "-patch-dir="+out, "./...")
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v\n%s", err, output)
+	}
+
+	// Nothing is changed in place.
+	if buf, _ := os.ReadFile(filepath.Join(dir, "a", "x.go")); string(buf) != src {
+		t.Errorf("a/x.go was modified:\n%s", buf)
+	}
+
+	// There's one patch per directory with changes.
+	want := `--- a/a/x.go
++++ b/a/x.go
+@@ -1,5 +1,5 @@
+ package a
+ 
+ func _(x int) {
+-	_ = int(x)
++	_ = x
+ }
+`
+	if buf, err := os.ReadFile(filepath.Join(out, "a", "unconvert.patch")); err != nil || string(buf) != want {
+		t.Errorf("got a/unconvert.patch %q (%v), want %q", buf, err, want)
+	}
+	if _, err := os.Stat(filepath.Join(out, "c", "unconvert.patch")); err == nil {
+		t.Errorf("got a patch for c, which has no changes")
+	}
+
+	// Applying the patches has the same effect as -apply.
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	applied := tempModule(t, "patch", files)
+	cmd = exec.Command(exePath, "-apply", "./...")
+	cmd.Dir = applied
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v\n%s", err, output)
+	}
+	cmd = exec.Command("git", "apply", filepath.Join(out, "a", "unconvert.patch"), filepath.Join(out, "b", "unconvert.patch"))
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git apply: %v\n%s", err, output)
+	}
+	for _, file := range []string{"a/x.go", "b/y.go"} {
+		got, _ := os.ReadFile(filepath.Join(dir, file))
+		want, _ := os.ReadFile(filepath.Join(applied, file))
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: got %s, want %s", file, got, want)
+		}
+	}
+}
+
+func TestScript(t *testing.T) {
+	for _, tool := range []string{"sh", "patch"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not found", tool)
+		}
+	}
+
+	const src = "package a\n\nfunc _(x int) {\n\t_ = int(x)\n}\n"
+	const want = "package a\n\nfunc _(x int) {\n\t_ = x\n}\n"
+	dir := tempModule(t, "script", map[string]string{
+		"a/x.go": src,
+		"b/y.go": "package b\n",
+	})
+	script := filepath.Join(t.TempDir(), "fix.sh")
+
+	cmd := exec.Command(exePath, "-script="+script, "./...")
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v\n%s", err, output)
+	}
+	if buf, _ := os.ReadFile(filepath.Join(dir, "a", "x.go")); string(buf) != src {
+		t.Errorf("a/x.go was modified:\n%s", buf)
+	}
+
+	// Running the script applies the edits.
+	cmd = exec.Command("sh", script)
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("sh: %v\n%s", err, output)
+	}
+	if buf, _ := os.ReadFile(filepath.Join(dir, "a", "x.go")); string(buf) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf, want)
+	}
+
+	// Running it again fails, since the file has changed.
+	cmd = exec.Command("sh", script)
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err == nil {
+		t.Errorf("sh succeeded on changed files\n%s", output)
+	}
+	if buf, _ := os.ReadFile(filepath.Join(dir, "a", "x.go")); string(buf) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf, want)
+	}
+}
+
+func TestScriptChanged(t *testing.T) {
+	for _, tool := range []string{"sh", "patch"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not found", tool)
+		}
+	}
+
+	dir := tempModule(t, "script", map[string]string{
+		"a/x.go": "package a\n\n// F does nothing.\nfunc F(x int) {\n\t_ = int(x)\n}\n",
+		"b/y.go": "package b\n\nfunc _(y int) {\n\t_ = int(y)\n}\n",
+	})
+	script := filepath.Join(t.TempDir(), "fix.sh")
+
+	cmd := exec.Command(exePath, "-script="+script, "./...")
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v\n%s", err, output)
+	}
+
+	// Change only a line of context, which patch would
+	// otherwise allow for.
+	changed := map[string]string{
+		"a/x.go": "package a\n\n// F does little.\nfunc F(x int) {\n\t_ = int(x)\n}\n",
+		"b/y.go": "package b\n\nfunc _(y int) {\n\t_ = int(y)\n}\n",
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a", "x.go"), []byte(changed["a/x.go"]), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// The script refuses to run, and changes neither file.
+	cmd = exec.Command("sh", script)
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err == nil {
+		t.Errorf("sh succeeded on changed files\n%s", output)
+	}
+	for name, want := range changed {
+		if buf, _ := os.ReadFile(filepath.Join(dir, name)); string(buf) != want {
+			t.Errorf("%s: got:\n%s\nwant:\n%s", name, buf, want)
+		}
+	}
+}
+
+func TestApplyStdout(t *testing.T) {
+	const src = "package stdout\n\nfunc _(x int) { _ = int(x) }\n"
+	const want = "package stdout\n\nfunc _(x int) { _ = x }\n"
+	dir := tempModule(t, "stdout", map[string]string{
+		"x.go": src,
+		"y.go": "package stdout\n\nfunc _(y int) { _ = int(y) }\n",
+	})
+
+	cmd := exec.Command(exePath, "-apply-stdout=x.go")
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(output) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", output, want)
+	}
+	if buf, err := os.ReadFile(filepath.Join(dir, "x.go")); err != nil || string(buf) != src {
+		t.Errorf("x.go was modified:\n%s", buf)
+	}
+
+	// Editors can pipe in an unsaved buffer.
+	cmd = exec.Command(exePath, "-stdin-file=z.go", "-apply-stdout=z.go")
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(strings.ReplaceAll(src, "_(x", "z(x"))
+	output, err = cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.ReplaceAll(want, "_(x", "z(x"); string(output) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", output, want)
+	}
+
+	// Type errors, common while editing, are reported on
+	// standard error, leaving the buffer intact.
+	const broken = "package stdout\n\nfunc w(x int) {\n\t_ = int(x)\n\t_ = undefined(x)\n\t_ = int(undefined)\n}\n"
+	cmd = exec.Command(exePath, "-stdin-file=w.go", "-apply-stdout=w.go")
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(broken)
+	output, err = cmd.Output()
+	if _, ok := err.(*exec.ExitError); !ok {
+		t.Fatalf("got %v, want exit error", err)
+	}
+	if want := strings.Replace(broken, "int(x)", "x", 1); string(output) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", output, want)
+	}
+	if stderr := err.(*exec.ExitError).Stderr; !bytes.Contains(stderr, []byte("Missing type")) {
+		t.Errorf("missing type notes not on standard error:\n%s", stderr)
+	}
 
+	// Conversions left in the file are reported on standard
+	// error, like with -apply.
+	const commented = "package stdout\n\nfunc c(a, b int) {\n\t_ = int(a /* c */)\n\t_ = int(b)\n}\n"
+	cmd = exec.Command(exePath, "-stdin-file=c.go", "-apply-stdout=c.go", "-format={{.Line}}")
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(commented)
+	output, err = cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+		t.Fatalf("got %v, want exit status 1", err)
+	}
+	if want := strings.Replace(commented, "int(b)", "b", 1); string(output) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", output, want)
+	}
+	if stderr := string(err.(*exec.ExitError).Stderr); stderr != "4\n" {
+		t.Errorf("got standard error %q, want %q", stderr, "4\n")
+	}
+}
+
+func TestPaths(t *testing.T) {
 	tests := []struct {
-		name string
-		dir  string
-		args []string
+		paths string
+		ok    func(string) bool
 	}{
-		{"relative", ".", []string{"./testdata"}},
-		{"dot", "./testdata", []string{"."}},
-		{"no-args", "./testdata", []string{}},
-		{"pattern", "./testdata", []string{"./..."}},
+		{"relative", func(file string) bool { return strings.HasPrefix(file, "testdata"+string(filepath.Separator)) }},
+		{"absolute", filepath.IsAbs},
 	}
 
 	for _, test := range tests {
-		t.Run(test.name, func(t *testing.T) {
-			cmd := exec.Command(exePath, test.args...)
-			cmd.Dir = test.dir
+		t.Run(test.paths, func(t *testing.T) {
+			cmd := exec.Command(exePath, "-paths="+test.paths, "./testdata")
+			output, _ := cmd.CombinedOutput()
+			for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+				file, _, _ := strings.Cut(line, ".go:")
+				if !test.ok(file) {
+					t.Errorf("unexpected path: %s", line)
+				}
+			}
+		})
+	}
+}
 
-			output, err := cmd.CombinedOutput()
-			if err == nil {
-				t.Fatal("expected to quit with an error code")
+func TestPathPrefixStrip(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, prefix := range []string{wd, wd + string(filepath.Separator)} {
+		cmd := exec.Command(exePath, "-paths=absolute", "-path-prefix-strip="+prefix, "-format={{.File}}", "./testdata")
+		output, _ := cmd.CombinedOutput()
+		for _, file := range strings.Fields(string(output)) {
+			if !strings.HasPrefix(file, "testdata"+string(filepath.Separator)) {
+				t.Errorf("%s: unexpected path: %s", prefix, file)
 			}
-			t.Log(string(output))
+		}
+	}
+
+	// Only whole path elements are stripped.
+	for _, test := range []struct {
+		paths, prefix string
+	}{
+		{"relative", "test"},
+		{"relative", "test" + string(filepath.Separator)},
+		{"absolute", filepath.Join(wd, "test")},
+	} {
+		cmd := exec.Command(exePath, "-paths="+test.paths, "-path-prefix-strip="+test.prefix, "-format={{.File}}", "./testdata")
+		output, _ := cmd.CombinedOutput()
+		files := strings.Fields(string(output))
+		if len(files) == 0 {
+			t.Errorf("%s: no output", test.prefix)
+		}
+		for _, file := range files {
+			if rel, _ := filepath.Rel(wd, file); !strings.HasPrefix(file, "testdata"+string(filepath.Separator)) && !strings.HasPrefix(rel, "testdata"+string(filepath.Separator)) {
+				t.Errorf("%s: unexpected path: %s", test.prefix, file)
+			}
+		}
+	}
+
+	// A root strips the leading separator of absolute paths.
+	root := filepath.VolumeName(wd) + string(filepath.Separator)
+	cmd := exec.Command(exePath, "-paths=absolute", "-path-prefix-strip="+root, "-format={{.File}}", "./testdata")
+	output, _ := cmd.CombinedOutput()
+	want := strings.TrimPrefix(filepath.Join(wd, "testdata"), root) + string(filepath.Separator)
+	files := strings.Fields(string(output))
+	if len(files) == 0 {
+		t.Errorf("%s: no output", root)
+	}
+	for _, file := range files {
+		if !strings.HasPrefix(file, want) {
+			t.Errorf("%s: unexpected path: %s", root, file)
+		}
+	}
+
+	// The source lines are still read from the stripped files.
+	cmd = exec.Command(exePath, "-path-prefix-strip=testdata", "-v", "./testdata")
+	output, err = cmd.CombinedOutput()
+	if !bytes.Contains(output, []byte("unnecessary conversion")) {
+		t.Errorf("-v: %v\n%s", err, output)
+	}
+}
+
+func TestCache(t *testing.T) {
+	dir := t.TempDir()
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+	file := filepath.Join(dir, "x.go")
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module cache\n\ngo 1.20\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	count := func() string {
+		t.Helper()
+		cmd := exec.Command(exePath, "-count", "-cache-dir="+cacheDir, ".")
+		cmd.Dir = dir
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("%v\n%s", err, output)
+		}
+		return strings.TrimSpace(string(output))
+	}
+
+	src := "package cache\n\nfunc _(x int) {\n\t_ = int(x)\n}\n"
+	if err := os.WriteFile(file, []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if got := count(); got != "1" {
+			t.Fatalf("run %d: got %s findings, want 1", i, got)
+		}
+	}
+	if entries, err := os.ReadDir(cacheDir); err != nil || len(entries) == 0 {
+		t.Fatalf("expected cache entries, got %v (%v)", entries, err)
+	}
+
+	src = "package cache\n\nfunc _(x int) {\n\t_ = int(x)\n\t_ = int(x)\n}\n"
+	if err := os.WriteFile(file, []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if got := count(); got != "2" {
+		t.Fatalf("after change: got %s findings, want 2", got)
+	}
+}
+
+func TestDeps(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":     "module deps\n\ngo 1.20\n",
+		"main.go":    "package main\n\nimport \"deps/lib\"\n\nfunc main() { lib.F(0) }\n",
+		"lib/lib.go": "package lib\n\nfunc F(x int) int { return int(x) }\n",
+	}
+	for name, src := range files {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(src), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"."}, "0"},
+		{[]string{"-deps", "."}, "1"},
+		{[]string{"-deps", "-deps-prefix=fmt", "."}, "0"},
+		{[]string{"-deps", "-deps-prefix=deps/", "."}, "1"},
+	} {
+		cmd := exec.Command(exePath, append([]string{"-count"}, test.args...)...)
+		cmd.Dir = dir
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("%v: %v\n%s", test.args, err, output)
+		}
+		if got := strings.TrimSpace(string(output)); got != test.want {
+			t.Errorf("%v: got %s findings, want %s", test.args, got, test.want)
+		}
+	}
+}
+
+func TestReachable(t *testing.T) {
+	dir := tempModule(t, "reach", map[string]string{
+		"cmd/app/main.go":       "package main\n\nimport \"reach/used\"\n\nfunc main() { _ = int(used.F(0)) }\n",
+		"used/used.go":          "package used\n\nimport \"reach/indirect\"\n\nfunc F(x int) int { return int(indirect.G(x)) }\n",
+		"indirect/indirect.go":  "package indirect\n\nfunc G(x int) int { return int(x) }\n",
+		"unused/unused.go":      "package unused\n\nfunc H(x int) int { return int(x) }\n",
+		"unused/unused_test.go": "package unused\n\nimport \"testing\"\n\nfunc TestH(t *testing.T) { _ = int(H(0)) }\n",
+	})
+	cache := t.TempDir()
+
+	for _, test := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"./..."}, "5"},
+		{[]string{"-reachable", "./..."}, "3"},
+		{[]string{"-reachable", "./used", "./unused"}, "0"},
+		// Cached results for the main package don't make
+		// the packages it imports unreachable.
+		{[]string{"-cache-dir=" + cache, "-reachable", "./cmd/app"}, "1"},
+		{[]string{"-cache-dir=" + cache, "-reachable", "./..."}, "3"},
+	} {
+		cmd := exec.Command(exePath, append([]string{"-count"}, test.args...)...)
+		cmd.Dir = dir
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("%v: %v\n%s", test.args, err, output)
+		}
+		if got := strings.TrimSpace(string(output)); got != test.want {
+			t.Errorf("%v: got %s findings, want %s", test.args, got, test.want)
+		}
+	}
+}
+
+func TestGlobPatterns(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":     "module glob\n\ngo 1.20\n",
+		"cmd/a/a.go": "package main\n\nfunc main() { x := 0; _ = int(x) }\n",
+		"cmd/b/b.go": "package main\n\nfunc main() { x := 0; _ = int(x) }\n",
+		"cmd/README": "not a package\n",
+		"other/o.go": "package other\n\nfunc _(x int) { _ = int(x) }\n",
+	}
+	for name, src := range files {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(src), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command(exePath, "-count", "./cmd/*")
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, output)
+	}
+	if got := strings.TrimSpace(string(output)); got != "2" {
+		t.Errorf("got %s findings, want 2", got)
+	}
+}
+
+// TestApply checks that -apply rewrites each testdata/apply/*.input
+// file into the corresponding .golden file.
+func TestApply(t *testing.T) {
+	inputs, err := filepath.Glob("testdata/apply/*.input")
+	if err != nil {
+		t.Fatal(err)
+	}
 
-			got, err := ParseOutput(t, "testdata", string(output))
+	for _, input := range inputs {
+		name := strings.TrimSuffix(filepath.Base(input), ".input")
+		t.Run(name, func(t *testing.T) {
+			src, err := os.ReadFile(input)
 			if err != nil {
 				t.Fatal(err)
 			}
-
-			expected, err := ParseDir("testdata")
+			want, err := os.ReadFile(strings.TrimSuffix(input, ".input") + ".golden")
 			if err != nil {
 				t.Fatal(err)
 			}
 
-			SortAnnotations(got)
-			SortAnnotations(expected)
+			dir := t.TempDir()
+			file := filepath.Join(dir, name+".go")
+			if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module apply\n\ngo 1.20\n"), 0666); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(file, src, 0666); err != nil {
+				t.Fatal(err)
+			}
 
-			need := map[Annotation]struct{}{}
-			for _, annotation := range expected {
-				need[annotation] = struct{}{}
+			// Extra flags may be given in a .flags file.
+			args := []string{"-apply"}
+			if flags, err := os.ReadFile(strings.TrimSuffix(input, ".input") + ".flags"); err == nil {
+				args = append(args, strings.Fields(string(flags))...)
 			}
+			args = append(args, ".")
 
-			for _, annotation := range got {
-				_, ok := need[annotation]
-				if ok {
-					delete(need, annotation)
-				} else {
-					t.Errorf("unexpected: %v", annotation)
-				}
+			// Conversions that aren't removed are reported,
+			// so only fail for unexpected errors.
+			cmd := exec.Command(exePath, args...)
+			cmd.Dir = dir
+			output, err := cmd.CombinedOutput()
+			t.Log(string(output))
+			if exitErr, ok := err.(*exec.ExitError); err != nil && (!ok || exitErr.ExitCode() != 1) {
+				t.Fatal(err)
+			}
+			if bytes.Contains(output, []byte("missing edits")) {
+				t.Errorf("some edits weren't applied")
 			}
 
-			for _, annotation := range expected {
-				_, ok := need[annotation]
-				if ok {
-					t.Errorf("missing: %v", annotation)
-				}
+			got, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(want) {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
 			}
 		})
 	}
 }
 
+// run runs the unconvert binary in dir with the given arguments,
+// expects it to report findings, and returns the parsed output.
+func run(t *testing.T, dir string, args ...string) []Annotation {
+	t.Helper()
+
+	cmd := exec.Command(exePath, args...)
+	cmd.Dir = dir
+
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatal("expected to quit with an error code")
+	}
+	t.Log(string(output))
+
+	got, err := ParseOutput(t, "testdata", string(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+// tempModule creates a module with the given path and files in a
+// temporary directory, and returns the directory.
+func tempModule(t *testing.T, path string, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	files["go.mod"] = "module " + path + "\n\ngo 1.20\n"
+	for name, src := range files {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(src), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// expected returns the annotations in testdata for which keep
+// returns true. If keep is nil, all annotations are returned.
+func expected(t *testing.T, keep func(Annotation) bool) []Annotation {
+	t.Helper()
+
+	all, err := ParseDir("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keep == nil {
+		return all
+	}
+
+	var res []Annotation
+	for _, ann := range all {
+		if keep(ann) {
+			res = append(res, ann)
+		}
+	}
+	return res
+}
+
+// sourceLine returns the testdata source line that ann annotates.
+func sourceLine(t *testing.T, ann Annotation) string {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", ann.File))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(string(data), "\n")[ann.Line-1]
+}
+
+// check reports any differences between got and expected.
+func check(t *testing.T, got, expected []Annotation) {
+	t.Helper()
+
+	SortAnnotations(got)
+	SortAnnotations(expected)
+
+	// Count annotations, since a line may have several.
+	need := map[Annotation]int{}
+	for _, annotation := range expected {
+		need[annotation]++
+	}
+
+	for _, annotation := range got {
+		if need[annotation] > 0 {
+			need[annotation]--
+		} else {
+			t.Errorf("unexpected: %v", annotation)
+		}
+	}
+
+	for _, annotation := range expected {
+		if need[annotation] > 0 {
+			need[annotation]--
+			t.Errorf("missing: %v", annotation)
+		}
+	}
+}
+
 type Annotation struct {
 	File    string
 	Line    int
@@ -126,16 +1890,32 @@ func ParseOutput(t *testing.T, dir, output string) ([]Annotation, error) {
 			return nil, err
 		}
 
+		// Drop the conversion's text, which follows the
+		// message, but keep any notes added after it.
+		msg := strings.TrimSpace(tokens[3])
+		if head, rest, ok := strings.Cut(msg, ": "); ok {
+			msg = head
+			if _, notes, ok := strings.Cut(rest, "; "); ok {
+				msg += "; " + notes
+			}
+		}
+
 		all = append(all, Annotation{
 			File:    tokens[0],
 			Line:    line,
-			Message: strings.TrimSpace(tokens[3]),
+			Message: msg,
 		})
 	}
 	return all, nil
 }
 
 func ParseDir(dir string) ([]Annotation, error) {
+	return parseDir(&build.Default, dir)
+}
+
+// parseDir is like ParseDir, but matches build constraints
+// against ctxt.
+func parseDir(ctxt *build.Context, dir string) ([]Annotation, error) {
 	var all []Annotation
 	files, err := os.ReadDir(dir)
 	if err != nil {
@@ -145,6 +1925,13 @@ func ParseDir(dir string) ([]Annotation, error) {
 		if filepath.Ext(file.Name()) != ".go" {
 			continue
 		}
+		// Skip files excluded by build constraints, just
+		// like the go command does.
+		if ok, err := ctxt.MatchFile(dir, file.Name()); err != nil {
+			return all, err
+		} else if !ok {
+			continue
+		}
 
 		xs, err := ParseFile(filepath.Join(dir, file.Name()))
 		if err != nil {
@@ -166,28 +1953,25 @@ func ParseFile(file string) ([]Annotation, error) {
 
 	var all []Annotation
 	for lineNumber, line := range strings.Split(string(data), "\n") {
-		p := strings.Index(line, "//@")
-		if p < 0 {
-			continue
+		// Each "//@" on a line starts an annotation.
+		msgs := strings.Split(line, "//@")
+		for _, msg := range msgs[1:] {
+			all = append(all, Annotation{
+				File:    filename,
+				Line:    lineNumber + 1,
+				Message: strings.TrimSpace(msg),
+			})
 		}
-
-		all = append(all, Annotation{
-			File:    filename,
-			Line:    lineNumber + 1,
-			Message: strings.TrimSpace(line[p+3:]),
-		})
 	}
 
 	return all, nil
 }
 
-func build(t *testing.T) (exePath string) {
-	exePath = filepath.Join(t.TempDir(), "test_unconvert.exe")
-
-	output, err := exec.Command("go", "build", "-o", exePath, ".").CombinedOutput()
-	if err != nil {
-		t.Fatalf("failed to build service program: %v\n%v", err, string(output))
+func BenchmarkUnconvert(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		cmd := exec.Command(exePath, "-count", "cmd/compile/internal/ssa")
+		if output, err := cmd.CombinedOutput(); err != nil {
+			b.Fatalf("%v\n%s", err, output)
+		}
 	}
-
-	return exePath
 }